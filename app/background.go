@@ -13,10 +13,11 @@ const (
 )
 
 type BackgroundButtonEvent struct {
-	X     uint64
-	Y     uint64
-	ID    int64
-	Event ButtonEventType
+	Canvas string
+	X      uint64
+	Y      uint64
+	ID     int64
+	Event  ButtonEventType
 }
 
 func BackgroundEventHandler(db ObbDb, c <-chan BackgroundButtonEvent, cfg *Config) {
@@ -88,3 +89,22 @@ func BackgroundComputeStatistics(db ObbDb, ctx context.Context, cfg *Config) {
 
 	log.Printf("Background statistics worker stopped")
 }
+
+func BackgroundRefreshGrid(db ObbDb, grid *GridSource, ctx context.Context, cfg *Config) {
+	log.Printf("Background grid refresh worker started")
+	ticker := time.NewTicker(cfg.GridRefreshInterval)
+	done := false
+	for !done {
+		select {
+		case <-ctx.Done():
+			log.Printf("Background grid refresh worker stopping")
+			done = true
+		case <-ticker.C:
+			if err := grid.Refresh(db); err != nil {
+				log.Printf("could not refresh grid geometry: %v", err)
+			}
+		}
+	}
+
+	log.Printf("Background grid refresh worker stopped")
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"math"
+	"time"
+
+	"github.com/cmcquillan/one-billion-buttons/dblib"
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
+)
+
+type HeatmapDb interface {
+	ReadPressCounts(canvas string, window time.Duration, ctx context.Context, page func(x int64, y int64, presses int64)) error
+}
+
+// ReadPressCounts counts the presses of every page of a canvas over the last
+// window.
+func (db *MinimapDbSql) ReadPressCounts(canvas string, window time.Duration, ctx context.Context, page func(x int64, y int64, presses int64)) error {
+	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
+		rows, err := dbc.QueryContext(ctx, `
+			select x_coord, y_coord, count(*)
+			from button_event
+			where canvas = $1 and event_type = 'press'
+			and created_at > now() - make_interval(secs => $2)
+			group by x_coord, y_coord`, canvas, window.Seconds())
+
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		var x, y, presses int64
+
+		for rows.Next() {
+			if err := rows.Scan(&x, &y, &presses); err != nil {
+				return err
+			}
+
+			page(x, y, presses)
+		}
+
+		return rows.Err()
+	})
+
+	return err
+}
+
+const HEATMAP_LOCK_TYPE = "heatmap_gen"
+
+// HeatmapWindowName names a window in object names and routes: 1h, 30m, 168h.
+func HeatmapWindowName(window time.Duration) string {
+	switch {
+	case window%time.Hour == 0:
+		return fmt.Sprintf("%dh", window/time.Hour)
+	case window%time.Minute == 0:
+		return fmt.Sprintf("%dm", window/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", window/time.Second)
+	}
+}
+
+// HeatmapName is the store object of the heatmap of a canvas over a window,
+// next to its minimap.
+func HeatmapName(canvas string, window time.Duration) string {
+	if canvas == DEFAULT_CANVAS {
+		return "heatmap-" + HeatmapWindowName(window) + ".png"
+	}
+
+	return "heatmap-" + canvas + "-" + HeatmapWindowName(window) + ".png"
+}
+
+// heatmapRamp runs from cold to hot. Pages without presses stay transparent.
+var heatmapRamp = [][3]float64{
+	{0x00, 0x00, 0x80},
+	{0x00, 0xc0, 0xff},
+	{0xff, 0xff, 0x00},
+	{0xff, 0x30, 0x00},
+	{0xff, 0xff, 0xff},
+}
+
+// heatmapColor maps a share of the hottest page onto the ramp.
+func heatmapColor(heat float64) []byte {
+	pos := heat * float64(len(heatmapRamp)-1)
+	i := int(pos)
+
+	if i >= len(heatmapRamp)-1 {
+		i, pos = len(heatmapRamp)-2, float64(len(heatmapRamp)-1)
+	}
+
+	f := pos - float64(i)
+	rgb := make([]byte, 3)
+
+	for c := range rgb {
+		rgb[c] = byte(math.Round(heatmapRamp[i][c] + (heatmapRamp[i+1][c]-heatmapRamp[i][c])*f))
+	}
+
+	return rgb
+}
+
+// RenderHeatmap draws one pixel per page colored by its presses over the
+// window. Heat is on a log scale so a few very busy pages do not wash out
+// everything else.
+func RenderHeatmap(db HeatmapDb, canvas string, grid *GridGeometry, window time.Duration, ctx context.Context) (*image.RGBA, error) {
+	counts := make(map[[2]int64]int64)
+	hottest := int64(0)
+
+	err := db.ReadPressCounts(canvas, window, ctx, func(x int64, y int64, presses int64) {
+		if x < 1 || y < 1 || x > grid.Cols || y > grid.Rows {
+			return
+		}
+
+		counts[[2]int64{x, y}] = presses
+
+		if presses > hottest {
+			hottest = presses
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	heatmap := image.NewRGBA(image.Rect(0, 0, int(grid.Cols), int(grid.Rows)))
+
+	for page, presses := range counts {
+		heat := math.Log1p(float64(presses)) / math.Log1p(float64(hottest))
+		setOpaque(heatmap, page[0]-1, page[1]-1, heatmapColor(heat))
+	}
+
+	return heatmap, nil
+}
+
+func BackgroundWorkerHeatmap(locker dblib.Lock, db HeatmapDb, store minimaplib.MinimapStore, grid *GridSource, ctx context.Context, cfg *Config) {
+	log.Print("Background heatmap maker started")
+
+	ticker := time.NewTicker(cfg.MinimapInitialInterval)
+
+tickerLoop:
+	for {
+		select {
+		case <-ticker.C:
+			created := false
+
+			for _, canvas := range grid.Canvases() {
+				g := grid.Current(canvas)
+
+				// Archived canvases are never pressed again
+				if g == nil || g.ReadOnly {
+					continue
+				}
+
+				if CreateHeatmaps(locker, db, store, canvas, g, ctx, cfg) {
+					created = true
+				}
+			}
+
+			if created {
+				ticker.Reset(cfg.HeatmapInterval)
+			}
+		case <-ctx.Done():
+			break tickerLoop
+		}
+	}
+
+	log.Print("Background heatmap maker stopped")
+}
+
+// CreateHeatmaps draws the heatmap of every configured window of a canvas.
+func CreateHeatmaps(locker dblib.Lock, db HeatmapDb, store minimaplib.MinimapStore, canvas string, grid *GridGeometry, ctx context.Context, cfg *Config) bool {
+	lockType := HEATMAP_LOCK_TYPE + ":" + canvas
+	lockVal, err := locker.AcquireLock(lockType, cfg.MinimapLockTimeout)
+
+	if err == dblib.ErrLockNotAcquired {
+		log.Printf("%s lock already acquired, deferring work", lockType)
+		return false
+	}
+
+	if err != nil {
+		log.Printf("error acquiring lock: %v", err)
+		return false
+	}
+
+	defer locker.ReleaseLock(lockVal)
+
+	e := png.Encoder{
+		CompressionLevel: png.BestCompression,
+	}
+
+	for _, window := range cfg.HeatmapWindows {
+		heatmap, err := RenderHeatmap(db, canvas, grid, window, ctx)
+
+		if err != nil {
+			log.Printf("could not draw %s heatmap over %s: %v", canvas, HeatmapWindowName(window), err)
+			return false
+		}
+
+		if err := publishPng(store, HeatmapName(canvas, window), heatmap, &e, ctx); err != nil {
+			log.Printf("could not publish png heatmap: %v", err)
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+type fakeHeatmapDb map[[2]int64]int64
+
+func (db fakeHeatmapDb) ReadPressCounts(canvas string, window time.Duration, ctx context.Context, page func(x int64, y int64, presses int64)) error {
+	for key, presses := range db {
+		page(key[0], key[1], presses)
+	}
+
+	return nil
+}
+
+func TestHeatmapWindowName(t *testing.T) {
+	names := map[time.Duration]string{
+		time.Hour:        "1h",
+		168 * time.Hour:  "168h",
+		30 * time.Minute: "30m",
+		90 * time.Second: "90s",
+	}
+
+	for window, expected := range names {
+		if name := HeatmapWindowName(window); name != expected {
+			t.Errorf("expected %s, got %s", expected, name)
+		}
+	}
+}
+
+func TestRenderHeatmap(t *testing.T) {
+	grid := &GridGeometry{Cols: 3, Rows: 2, ButtonsPerPage: 4}
+	db := fakeHeatmapDb{{1, 1}: 1, {3, 2}: 100, {4, 1}: 5}
+
+	heatmap, err := RenderHeatmap(db, "main", grid, time.Hour, context.Background())
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if c := heatmap.RGBAAt(2, 1); c.R != 0xff || c.G != 0xff || c.B != 0xff || c.A != 0xff {
+		t.Errorf("expected the hottest page at the top of the ramp, got %v", c)
+	}
+
+	cold := heatmap.RGBAAt(0, 0)
+
+	if cold.A != 0xff || cold.B < cold.R {
+		t.Errorf("expected a single press to be drawn cold, got %v", cold)
+	}
+
+	if c := heatmap.RGBAAt(1, 0); c.A != 0 {
+		t.Errorf("expected a page without presses to be transparent, got %v", c)
+	}
+}
+
+func TestHeatmapWindowsConfig(t *testing.T) {
+	t.Setenv("PG_CONNECTION_STRING", "postgres://")
+	t.Setenv("HEATMAP_WINDOWS", "15m,24h")
+
+	cfg, err := LoadConfig()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !slices.Equal(cfg.HeatmapWindows, []time.Duration{15 * time.Minute, 24 * time.Hour}) {
+		t.Errorf("unexpected windows %v", cfg.HeatmapWindows)
+	}
+}
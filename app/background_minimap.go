@@ -1,98 +1,84 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"database/sql"
 	"image"
-	"image/color"
 	"image/png"
 	"log"
-	"os"
 	"time"
 
 	"github.com/cmcquillan/one-billion-buttons/dblib"
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
 )
 
-type MinimapItem struct {
-	X   int64
-	Y   int64
-	RGB []byte
-}
-
 type MinimapDb interface {
-	BeginMinimapStreaming(stream chan *MinimapItem, ctx context.Context) error
-	GetImageDimensions() (x int64, y int64, e error)
+	minimaplib.PageDb
+	ReadPageRows(canvas string, yFrom int64, yTo int64, ctx context.Context, page func(x int64, y int64, buttons []byte)) error
 }
 
+// MinimapDbSql reads pages for the minimap worker and press counts for the
+// heatmap worker.
 type MinimapDbSql struct {
-	connStr string
+	minimaplib.MinimapDbSql
 }
 
-func (db *MinimapDbSql) GetConnectionString() string {
-	return db.connStr
+// minimapState is the last minimap drawn for a canvas, with the geometry it
+// was drawn for. It is kept between runs so a run only has to draw the pages
+// written since the last one.
+type minimapState struct {
+	*minimaplib.Minimap
+	grid GridGeometry
 }
 
-func (db *MinimapDbSql) GetImageDimensions() (x int64, y int64, e error) {
-
-	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
-		row := dbc.QueryRow("select max(x_coord), max(y_coord) from button")
-
-		scanErr := row.Scan(&x, &y)
-		return scanErr
-	})
-
-	return x, y, err
+// stale reports whether the minimap has to be drawn from scratch. Pages only
+// disappear when a season starts or a snapshot replaces the canvas, which the
+// change sequence cannot tell, so every state also expires after a while.
+func (s *minimapState) stale(grid *GridGeometry, cfg *Config) bool {
+	return s == nil ||
+		cfg.MinimapIncrementalInterval <= 0 ||
+		s.grid != *grid ||
+		time.Since(s.FullAt) >= cfg.MinimapIdleInterval
 }
 
-func (db *MinimapDbSql) BeginMinimapStreaming(stream chan *MinimapItem, ctx context.Context) error {
-	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
-
-		// Yep, query literally everything and stream to the application. Dirty reads are fine
-		rows, err := dbc.QueryContext(ctx, "set transaction isolation level read uncommitted; select x_coord, y_coord, map_value from button;")
-
-		if err != nil {
-			return err
-		}
-
-		defer rows.Close()
-		defer close(stream)
-
-		count := 0
-
-		for rows.Next() {
-			cErr := ctx.Err()
-			if cErr != nil {
-				log.Printf("aborting minimap stream due to context error: %v", cErr)
-				return cErr
-			}
-
-			mmap := MinimapItem{}
-
-			rows.Scan(&mmap.X, &mmap.Y, &mmap.RGB)
-			count++
-			stream <- &mmap
-		}
-
-		log.Printf("scanned %d rows for minimap", count)
-		return nil
-	})
+// MinimapInterval is how often the minimap is brought up to date. Without
+// incremental runs every run draws everything, so they stay further apart.
+func MinimapInterval(cfg *Config) time.Duration {
+	if cfg.MinimapIncrementalInterval > 0 {
+		return cfg.MinimapIncrementalInterval
+	}
 
-	return err
+	return cfg.MinimapIdleInterval
 }
 
-const MINIMAP_LOCK_TYPE = "minimap_gen"
-
-func BackgroundWorkerMinimap(locker dblib.Lock, db MinimapDb, ctx context.Context, cfg *Config) {
+func BackgroundWorkerMinimap(locker dblib.Lock, db MinimapDb, minimaps *MinimapCache, grid *GridSource, ctx context.Context, cfg *Config) {
 	log.Print("Background minimap maker started")
 
 	ticker := time.NewTicker(cfg.MinimapInitialInterval)
+	states := make(map[string]*minimapState)
 
 tickerLoop:
 	for {
 		select {
 		case <-ticker.C:
-			if CreateMinimap(locker, db, ctx, cfg) {
-				ticker.Reset(cfg.MinimapIdleInterval)
+			created := false
+
+			for _, canvas := range grid.Canvases() {
+				g := grid.Current(canvas)
+
+				if g == nil || (g.ReadOnly && minimapExists(minimaps, canvas, ctx)) {
+					// Archived canvases never change once their final minimap is drawn
+					delete(states, canvas)
+					continue
+				}
+
+				if CreateMinimap(locker, db, minimaps, canvas, g, states, ctx, cfg) {
+					created = true
+				}
+			}
+
+			if created {
+				ticker.Reset(MinimapInterval(cfg))
 			}
 		case <-ctx.Done():
 			break tickerLoop
@@ -102,12 +88,21 @@ tickerLoop:
 	log.Print("Background minimap maker stopped")
 }
 
-func CreateMinimap(locker dblib.Lock, db MinimapDb, ctx context.Context, cfg *Config) bool {
+func minimapExists(minimaps *MinimapCache, canvas string, ctx context.Context) bool {
+	_, err := minimaps.Get(canvas, minimaplib.MINIMAP_PNG, ctx)
+	return err == nil
+}
 
-	lockVal, err := locker.AcquireLock(MINIMAP_LOCK_TYPE, cfg.MinimapLockTimeout)
+// CreateMinimap brings the minimap of a canvas up to date. The first run, and
+// any run after the state went stale, draws every page. The others only draw
+// pages whose change_seq is past the highest one drawn so far.
+func CreateMinimap(locker dblib.Lock, db MinimapDb, minimaps *MinimapCache, canvas string, grid *GridGeometry, states map[string]*minimapState, ctx context.Context, cfg *Config) bool {
+
+	lockType := minimaplib.MinimapLockType(canvas)
+	lockVal, err := locker.AcquireLock(lockType, cfg.MinimapLockTimeout)
 
 	if err == dblib.ErrLockNotAcquired {
-		log.Printf("%s lock already acquired, deferring work", MINIMAP_LOCK_TYPE)
+		log.Printf("%s lock already acquired, deferring work", lockType)
 		return false
 	}
 
@@ -118,54 +113,85 @@ func CreateMinimap(locker dblib.Lock, db MinimapDb, ctx context.Context, cfg *Co
 
 	defer locker.ReleaseLock(lockVal)
 
-	log.Printf("lock %s acquired for %s", lockVal.Value, lockVal.Type)
+	start := time.Now()
+	state := states[canvas]
+	full := state.stale(grid, cfg)
+	scanned := int64(0)
+
+	if full {
+		log.Printf("lock %s acquired for %s, drawing every page", lockVal.Value, lockVal.Type)
 
-	mmChan := make(chan *MinimapItem, cfg.MinimapChannelSize)
+		var m *minimaplib.Minimap
+		m, scanned, err = minimaplib.DrawFullMinimap(db, canvas, grid.Cols, grid.Rows, ctx, &cfg.RenderConfig)
+		state = &minimapState{Minimap: m, grid: *grid}
+	} else {
+		var changed bool
+		changed, scanned, err = state.DrawChangedPages(db, canvas, cfg.MinimapChangeOverlap, ctx)
 
-	x, y, err := db.GetImageDimensions()
+		if err == nil && !changed {
+			return true
+		}
+	}
 
 	if err != nil {
-		log.Printf("unable to get correct dimensions for map: %v", err)
+		// The next run starts over rather than trust a half drawn image
+		delete(states, canvas)
+		log.Printf("could not draw %s minimap: %v", canvas, err)
 		return false
 	}
 
-	go db.BeginMinimapStreaming(mmChan, ctx)
-
-	minimap := image.NewRGBA64(image.Rect(0, 0, int(x), int(y)))
-
-	for mmItem := range mmChan {
-		alpha := 0
+	states[canvas] = state
 
-		if mmItem.RGB[0]+mmItem.RGB[1]+mmItem.RGB[2] > 0 {
-			alpha = 255
-		}
-
-		c := color.RGBA{
-			R: uint8(mmItem.RGB[0]),
-			G: uint8(mmItem.RGB[1]),
-			B: uint8(mmItem.RGB[2]),
-			A: uint8(alpha),
-		}
+	// Every format comes from the same drawn image
+	variants, err := minimaplib.EncodeMinimap(state.Image, cfg.DrawnFormats())
 
-		minimap.Set(int(mmItem.X), int(mmItem.Y), c)
+	if err != nil {
+		log.Printf("could not encode minimap: %v", err)
+		return false
 	}
 
-	e := png.Encoder{
-		CompressionLevel: png.BestCompression,
+	info := minimaplib.MinimapInfo{
+		GeneratedAt:  time.Now(),
+		DurationMs:   time.Since(start).Milliseconds(),
+		Width:        grid.Cols,
+		Height:       grid.Rows,
+		PagesScanned: scanned,
+		Full:         full,
 	}
 
-	file, err := os.OpenFile("./static/minimap.png", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		log.Printf("failed to open minimap file: %v", err)
+	if err := minimaps.Publish(canvas, variants, info, ctx); err != nil {
+		log.Printf("could not publish minimap: %v", err)
 		return false
 	}
 
-	err = e.Encode(file, minimap)
+	// A missed snapshot is taken by a later run
+	if _, err := minimaplib.ArchiveMinimap(minimaps.Store, canvas, variants[minimaplib.MINIMAP_PNG], info, ctx, &cfg.ArchiveConfig); err != nil {
+		log.Printf("could not archive %s minimap: %v", canvas, err)
+	}
 
-	if err != nil {
-		log.Printf("could not encode png minimap: %v", err)
-		return false
+	// Tiles are drawn from the raw buttons, so they only follow full runs
+	if cfg.MinimapTiles && full {
+		start := time.Now()
+		written, err := RenderMinimapTiles(db, minimaps.Store, canvas, grid, cfg.MinimapColorMode, ctx)
+
+		if err != nil {
+			log.Printf("could not render minimap tiles: %v", err)
+			return false
+		}
+
+		log.Printf("rendered %d minimap tiles for %s in %v", written, canvas, time.Since(start))
 	}
 
 	return true
 }
+
+// publishPng encodes an image and puts it into the store.
+func publishPng(store minimaplib.MinimapStore, name string, img image.Image, encoder *png.Encoder, ctx context.Context) error {
+	buf := bytes.Buffer{}
+
+	if err := encoder.Encode(&buf, img); err != nil {
+		return err
+	}
+
+	return store.Put(ctx, name, "image/png", buf.Bytes())
+}
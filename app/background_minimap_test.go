@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cmcquillan/one-billion-buttons/dblib"
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
+)
+
+type fakeLock struct{}
+
+func (l *fakeLock) AcquireLock(lockType string, timeout time.Duration) (*dblib.LockValue, error) {
+	return &dblib.LockValue{Type: lockType, Value: "test"}, nil
+}
+
+func (l *fakeLock) ReleaseLock(lockValue *dblib.LockValue) error {
+	return nil
+}
+
+func TestCreateMinimapIncremental(t *testing.T) {
+	minimaps := NewMinimapCache(&minimaplib.LocalStore{Dir: t.TempDir()}, time.Minute)
+
+	cfg := &Config{
+		MinimapIdleInterval:        time.Hour,
+		MinimapIncrementalInterval: time.Second,
+		MinimapChangeOverlap:       2,
+		RenderConfig: minimaplib.RenderConfig{
+			MinimapWorkers:   2,
+			MinimapShardCols: 3,
+			MinimapColorMode: minimaplib.MINIMAP_COLOR_MEAN,
+		},
+	}
+
+	grid := &GridGeometry{Cols: 4, Rows: 4, ButtonsPerPage: 4}
+	// Pages of 4 buttons all pressed in one color, which is their mean
+	db := &minimaplib.MemoryPageDb{Items: []*minimaplib.MinimapItem{{X: 1, Y: 1, Buttons: bytes.Repeat([]byte{0xff, 0, 0}, 4), Seq: 10}}}
+	states := make(map[string]*minimapState)
+
+	if !CreateMinimap(&fakeLock{}, db, minimaps, "main", grid, states, context.Background(), cfg) {
+		t.Fatal("expected the first minimap to be drawn")
+	}
+
+	state := states["main"]
+
+	if state == nil || state.LastSeq != 10 {
+		t.Fatalf("expected a state at change 10, got %+v", state)
+	}
+
+	fullAt := state.FullAt
+
+	// A write that committed late below the last change is still in the overlap
+	db.Items = append(db.Items,
+		&minimaplib.MinimapItem{X: 2, Y: 3, Buttons: bytes.Repeat([]byte{0, 0xff, 0}, 4), Seq: 9},
+		&minimaplib.MinimapItem{X: 4, Y: 4, Buttons: bytes.Repeat([]byte{0, 0, 0xff}, 4), Seq: 11})
+
+	if !CreateMinimap(&fakeLock{}, db, minimaps, "main", grid, states, context.Background(), cfg) {
+		t.Fatal("expected the changed pages to be drawn")
+	}
+
+	if states["main"] != state || state.FullAt != fullAt {
+		t.Fatal("expected the minimap to be patched rather than drawn again")
+	}
+
+	if state.LastSeq != 11 {
+		t.Errorf("expected the state to move to change 11, got %d", state.LastSeq)
+	}
+
+	for _, item := range db.Items {
+		if c := state.Image.RGBAAt(int(item.X)-1, int(item.Y)-1); c.R != item.Buttons[0] || c.G != item.Buttons[1] || c.B != item.Buttons[2] || c.A != 0xff {
+			t.Errorf("page %d,%d was drawn as %v", item.X, item.Y, c)
+		}
+	}
+
+	// Geometry changes draw everything again
+	grid = &GridGeometry{Cols: 5, Rows: 4, ButtonsPerPage: 4}
+
+	if !CreateMinimap(&fakeLock{}, db, minimaps, "main", grid, states, context.Background(), cfg) || states["main"] == state {
+		t.Error("expected an expanded grid to draw a new minimap")
+	}
+}
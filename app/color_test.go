@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		name      string
+		color     string
+		expected  []byte
+		expectErr bool
+	}{
+		{name: "six digit hex", color: "5f2300", expected: []byte{95, 35, 0}},
+		{name: "six digit hex with hash", color: "#379217", expected: []byte{55, 146, 23}},
+		{name: "three digit hex", color: "#f0a", expected: []byte{255, 0, 170}},
+		{name: "surrounding whitespace", color: "  #FFF ", expected: []byte{255, 255, 255}},
+		{name: "named color", color: "RebeccaPurple", expected: []byte{102, 51, 153}},
+		{name: "rgb with commas", color: "rgb(255, 128, 0)", expected: []byte{255, 128, 0}},
+		{name: "rgb with spaces", color: "rgb(1 2 3)", expected: []byte{1, 2, 3}},
+		{name: "rgb with percentages", color: "rgb(100%, 0%, 50%)", expected: []byte{255, 0, 128}},
+		{name: "hsl red", color: "hsl(0, 100%, 50%)", expected: []byte{255, 0, 0}},
+		{name: "hsl green with deg", color: "hsl(120deg 100% 25%)", expected: []byte{0, 128, 0}},
+		{name: "hsl negative hue wraps", color: "hsl(-120, 100%, 50%)", expected: []byte{0, 0, 255}},
+		{name: "hsl white", color: "hsl(0, 0%, 100%)", expected: []byte{255, 255, 255}},
+		{name: "empty", color: "", expectErr: true},
+		{name: "four digit hex", color: "#ffff", expectErr: true},
+		{name: "eight digit hex", color: "#ffaabb00", expectErr: true},
+		{name: "hex with garbage", color: "#ffaabz", expectErr: true},
+		{name: "hex with sign", color: "+fffff", expectErr: true},
+		{name: "unknown name", color: "notacolor", expectErr: true},
+		{name: "rgb out of range", color: "rgb(256, 0, 0)", expectErr: true},
+		{name: "rgb negative", color: "rgb(-1, 0, 0)", expectErr: true},
+		{name: "rgb too few args", color: "rgb(1, 2)", expectErr: true},
+		{name: "rgb with alpha", color: "rgb(1, 2, 3, 4)", expectErr: true},
+		{name: "rgb unterminated", color: "rgb(1, 2, 3", expectErr: true},
+		{name: "hsl without percent", color: "hsl(0, 100, 50)", expectErr: true},
+		{name: "hsl bad hue", color: "hsl(red, 100%, 50%)", expectErr: true},
+		{name: "rgb hex float and exponent", color: "rgb(0x1p4, 1e2, 0)", expectErr: true},
+		{name: "hsl exponent hue", color: "hsl(1e2, 100%, 50%)", expectErr: true},
+		{name: "rgb infinity", color: "rgb(inf, 0, 0)", expectErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := ParseColor(test.color)
+
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("expected error for %q but got %v", test.color, result)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error for %q: %v", test.color, err)
+			}
+
+			if !bytes.Equal(result, test.expected) {
+				t.Errorf("ParseColor(%q) = %v; expected %v", test.color, result, test.expected)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 
 	"github.com/cmcquillan/one-billion-buttons/dblib"
@@ -18,12 +19,22 @@ type ButtonStat struct {
 	Order    int64  `json:"order"`
 }
 
+// ErrSeasonArchiveName means a season could not be archived under its name,
+// because the name is too long or taken by another canvas.
+var ErrSeasonArchiveName = errors.New("season archive name is not available")
+
 type ObbDb interface {
-	GetPageButtonState(x int64, y int64) ([]byte, error)
-	SetButtonState(x int64, y int64, index int64, rgb []byte) error
-	GetButtonStats() ([]ButtonStat, error)
+	GetCanvases() (map[string]*GridGeometry, error)
+	ExpandGrid(canvas string, addCols int64, addRows int64) error
+	StartSeason(canvas string) error
+	GetSeasons(canvas string) ([]SeasonDto, error)
+	GetPageButtonState(canvas string, x int64, y int64) ([]byte, int64, error)
+	GetPagePressTimes(canvas string, x int64, y int64) ([]byte, error)
+	GetPagePressVersions(canvas string, x int64, y int64) ([]byte, error)
+	SetButtonState(canvas string, x int64, y int64, index int64, rgb []byte) error
+	GetButtonStats(canvas string) ([]ButtonStat, error)
 	LogButtonEvents(events []BackgroundButtonEvent) error
-	AdjustStat(statKey string, delta int64) error
+	AdjustStat(canvas string, statKey string, delta int64) error
 	RefreshStats() error
 }
 
@@ -35,6 +46,102 @@ func (db *ObbDbSql) GetConnectionString() string {
 	return db.connStr
 }
 
+func (db *ObbDbSql) GetCanvases() (map[string]*GridGeometry, error) {
+	canvases := make(map[string]*GridGeometry)
+
+	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
+		rows, err := dbc.Query(`
+			select c.name, c.cols, c.rows, c.id_cols, g.buttons_per_page, c.read_only, c.season 
+			from canvas c 
+			cross join grid_geometry g 
+			where g.id = 1`)
+
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var name string
+			grid := GridGeometry{}
+
+			if err := rows.Scan(&name, &grid.Cols, &grid.Rows, &grid.IdCols, &grid.ButtonsPerPage, &grid.ReadOnly, &grid.Season); err != nil {
+				return err
+			}
+
+			if err := grid.Validate(); err != nil {
+				return err
+			}
+
+			canvases[name] = &grid
+		}
+
+		return rows.Err()
+	})
+
+	return canvases, err
+}
+
+func (db *ObbDbSql) ExpandGrid(canvas string, addCols int64, addRows int64) error {
+	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
+		_, err := dbc.Exec("call expand_grid($1, $2, $3)", canvas, addCols, addRows)
+		return err
+	})
+
+	return err
+}
+
+// StartSeason returns ErrSeasonArchiveName when start_season finds the
+// archive name too long or already taken.
+func (db *ObbDbSql) StartSeason(canvas string) error {
+	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
+		_, err := dbc.Exec("call start_season($1)", canvas)
+		return err
+	})
+
+	var pqErr *pq.Error
+
+	if errors.As(err, &pqErr) && (pqErr.Code.Name() == "unique_violation" || pqErr.Code.Name() == "name_too_long") {
+		return fmt.Errorf("%w: %s", ErrSeasonArchiveName, pqErr.Message)
+	}
+
+	return err
+}
+
+// GetSeasons lists the archives of a canvas, oldest season first.
+func (db *ObbDbSql) GetSeasons(canvas string) ([]SeasonDto, error) {
+	seasons := make([]SeasonDto, 0)
+
+	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
+		rows, err := dbc.Query(`
+			select name, season, cols, rows, archived_at 
+			from canvas 
+			where read_only and archived_from = $1 
+			order by season`, canvas)
+
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			season := SeasonDto{}
+
+			if err := rows.Scan(&season.Canvas, &season.Season, &season.Cols, &season.Rows, &season.ArchivedAt); err != nil {
+				return err
+			}
+
+			seasons = append(seasons, season)
+		}
+
+		return rows.Err()
+	})
+
+	return seasons, err
+}
+
 func (db *ObbDbSql) RefreshStats() error {
 	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
 		_, err := dbc.Exec("call update_button_stats()")
@@ -44,9 +151,9 @@ func (db *ObbDbSql) RefreshStats() error {
 	return err
 }
 
-func (db *ObbDbSql) AdjustStat(statKey string, delta int64) error {
+func (db *ObbDbSql) AdjustStat(canvas string, statKey string, delta int64) error {
 	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
-		_, err := dbc.Exec("update button_stat set val = val + $1 where stat_key = $2", delta, statKey)
+		_, err := dbc.Exec("update button_stat set val = val + $1 where canvas = $2 and stat_key = $3", delta, canvas, statKey)
 		return err
 	})
 
@@ -61,10 +168,10 @@ func (db *ObbDbSql) LogButtonEvents(events []BackgroundButtonEvent) error {
 			return err
 		}
 
-		stmt, _ := txn.Prepare(pq.CopyIn("button_event", "x_coord", "y_coord", "button_id", "event_type"))
+		stmt, _ := txn.Prepare(pq.CopyIn("button_event", "canvas", "x_coord", "y_coord", "button_id", "event_type"))
 
 		for _, evt := range events {
-			_, err = stmt.Exec(evt.X, evt.Y, evt.ID, evt.Event)
+			_, err = stmt.Exec(evt.Canvas, evt.X, evt.Y, evt.ID, evt.Event)
 			if err != nil {
 				log.Printf("could not prepare bulk insert %v", err)
 				return err
@@ -89,13 +196,13 @@ func (db *ObbDbSql) LogButtonEvents(events []BackgroundButtonEvent) error {
 	return err
 }
 
-func (db *ObbDbSql) GetButtonStats() ([]ButtonStat, error) {
+func (db *ObbDbSql) GetButtonStats(canvas string) ([]ButtonStat, error) {
 	rows := 0
 
 	var res *sql.Rows
 
 	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
-		iRes, err := dbc.Query("select stat_key, stat_name, stat_desc, val, scale, \"order\" from button_stat")
+		iRes, err := dbc.Query("select stat_key, stat_name, stat_desc, val, scale, \"order\" from button_stat where canvas = $1", canvas)
 		res = iRes
 		return err
 	})
@@ -117,31 +224,61 @@ func (db *ObbDbSql) GetButtonStats() ([]ButtonStat, error) {
 	return result[0:rows], err
 }
 
-func (db *ObbDbSql) GetPageButtonState(x int64, y int64) ([]byte, error) {
-	var rows *sql.Rows
+func (db *ObbDbSql) GetPageButtonState(canvas string, x int64, y int64) ([]byte, int64, error) {
+	var bytes []byte
+	var version int64
 
 	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
-		iRows, err := dbc.Query("select buttons from button where x_coord = $1 and y_coord = $2;", x, y)
-		rows = iRows
-		return err
+		// Pages are created on their first press, a missing page is empty
+		row := dbc.QueryRow(`
+			select 
+				coalesce(b.buttons, decode(repeat('00', g.buttons_per_page * 3), 'hex')), 
+				coalesce(b.version, 0) 
+			from grid_geometry g 
+			left join button b on b.canvas = $1 and b.x_coord = $2 and b.y_coord = $3 
+			where g.id = 1;`, canvas, x, y)
+
+		return row.Scan(&bytes, &version)
 	})
 
-	if rows.Next() {
-		var bytes []byte
-		if serr := rows.Scan(&bytes); err != nil {
-			log.Printf("could not scan button: %v", err)
-			return nil, serr
-		}
+	return bytes, version, err
+}
 
-		return bytes, err
-	}
+func (db *ObbDbSql) GetPagePressTimes(canvas string, x int64, y int64) ([]byte, error) {
+	var times []byte
+
+	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
+		row := dbc.QueryRow(`
+			select coalesce(b.pressed_at, decode(repeat('00', g.buttons_per_page * 4), 'hex')) 
+			from grid_geometry g 
+			left join button b on b.canvas = $1 and b.x_coord = $2 and b.y_coord = $3 
+			where g.id = 1;`, canvas, x, y)
+
+		return row.Scan(&times)
+	})
+
+	return times, err
+}
+
+func (db *ObbDbSql) GetPagePressVersions(canvas string, x int64, y int64) ([]byte, error) {
+	var versions []byte
+
+	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
+		row := dbc.QueryRow(`
+			select coalesce(b.pressed_version, decode(repeat('00', g.buttons_per_page * 4), 'hex')) 
+			from grid_geometry g 
+			left join button b on b.canvas = $1 and b.x_coord = $2 and b.y_coord = $3 
+			where g.id = 1;`, canvas, x, y)
+
+		return row.Scan(&versions)
+	})
 
-	return nil, errors.New("coordinate not found")
+	return versions, err
 }
 
-func (db *ObbDbSql) SetButtonState(x int64, y int64, index int64, rgb []byte) error {
+func (db *ObbDbSql) SetButtonState(canvas string, x int64, y int64, index int64, rgb []byte) error {
 	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
-		stmt, err := dbc.Prepare("call set_button_color ($1, $2, $3, $4)")
+		stmt, err := dbc.Prepare("call set_button_color ($1, $2, $3, $4, $5)")
 
 		if err != nil {
 			log.Fatal(err)
@@ -150,8 +287,8 @@ func (db *ObbDbSql) SetButtonState(x int64, y int64, index int64, rgb []byte) er
 
 		defer stmt.Close()
 
-		log.Printf("setting (%d, %d, %d) to %s", x, y, index, ToHex(rgb))
-		_, err2 := stmt.Exec(x, y, index, rgb)
+		log.Printf("setting %s (%d, %d, %d) to %s", canvas, x, y, index, ToHex(rgb))
+		_, err2 := stmt.Exec(canvas, x, y, index, rgb)
 		return err2
 	})
 
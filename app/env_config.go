@@ -3,6 +3,7 @@ package main
 import (
 	"time"
 
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
 	"github.com/kelseyhightower/envconfig"
 )
 
@@ -10,9 +11,18 @@ type Config struct {
 	// Settings
 	RunMinimapInMain bool `envconfig:"RUN_MINIMAP_IN_MAIN" default:"false"`
 
+	// Admin API bearer token, admin routes are disabled when empty
+	AdminToken string `envconfig:"ADMIN_TOKEN" default:""`
+
 	// Database connection
 	PgConnectionString string `envconfig:"PG_CONNECTION_STRING" required:"true"`
 
+	// Schema migration configuration
+	AutoMigrate          bool          `envconfig:"AUTO_MIGRATE" default:"false"`
+	RequireCurrentSchema bool          `envconfig:"REQUIRE_CURRENT_SCHEMA" default:"true"`
+	MigrateLockTimeout   time.Duration `envconfig:"MIGRATE_LOCK_TIMEOUT" default:"1h"`
+	SchemaCheckInterval  time.Duration `envconfig:"SCHEMA_CHECK_INTERVAL" default:"30s"`
+
 	// Background event handler configuration
 	EventBatchInterval time.Duration `envconfig:"EVENT_BATCH_INTERVAL" default:"2s"`
 	EventHandlerSleep  time.Duration `envconfig:"EVENT_HANDLER_SLEEP" default:"100ms"`
@@ -20,14 +30,35 @@ type Config struct {
 	// Statistics computation configuration
 	StatisticsInterval time.Duration `envconfig:"STATISTICS_INTERVAL" default:"120s"`
 
+	// Grid geometry configuration
+	GridRefreshInterval   time.Duration `envconfig:"GRID_REFRESH_INTERVAL" default:"30s"`
+	GridExpandLockTimeout time.Duration `envconfig:"GRID_EXPAND_LOCK_TIMEOUT" default:"1h"`
+
 	// Minimap generation configuration
 	MinimapInitialInterval time.Duration `envconfig:"MINIMAP_INITIAL_INTERVAL" default:"1s"`
 	MinimapIdleInterval    time.Duration `envconfig:"MINIMAP_IDLE_INTERVAL" default:"10m"`
 	MinimapLockTimeout     time.Duration `envconfig:"MINIMAP_LOCK_TIMEOUT" default:"10m"`
+	MinimapTiles           bool          `envconfig:"MINIMAP_TILES" default:"true"`
+
+	// Where minimaps, tiles and heatmaps are published, see minimaplib.StoreConfig
+	minimaplib.StoreConfig
+
+	// Colors, formats and shards of the minimap, see minimaplib.RenderConfig
+	minimaplib.RenderConfig
+
+	// Rolling archive of minimap snapshots, see minimaplib.ArchiveConfig
+	minimaplib.ArchiveConfig
+
+	// Runs in between full minimaps only draw changed pages, 0 draws everything every run
+	MinimapIncrementalInterval time.Duration `envconfig:"MINIMAP_INCREMENTAL_INTERVAL" default:"5s"`
+	MinimapChangeOverlap       int64         `envconfig:"MINIMAP_CHANGE_OVERLAP" default:"1000"`
+
+	// Press heatmaps, drawn for every window by the minimap instance
+	HeatmapInterval time.Duration   `envconfig:"HEATMAP_INTERVAL" default:"1m"`
+	HeatmapWindows  []time.Duration `envconfig:"HEATMAP_WINDOWS" default:"1h,24h,168h"`
 
 	// Channel and buffer size configuration
 	ButtonEventChannelSize int `envconfig:"BUTTON_EVENT_CHANNEL_SIZE" default:"2000"`
-	MinimapChannelSize     int `envconfig:"MINIMAP_CHANNEL_SIZE" default:"10000"`
 	EventBatchCapacity     int `envconfig:"EVENT_BATCH_CAPACITY" default:"1000"`
 	SignalChannelSize      int `envconfig:"SIGNAL_CHANNEL_SIZE" default:"2"`
 }
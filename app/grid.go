@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"maps"
+	"slices"
+	"sync/atomic"
+
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
+)
+
+// DEFAULT_CANVAS is the canvas served by the routes without a canvas name.
+// Its minimap keeps the original object names.
+const DEFAULT_CANVAS = minimaplib.DEFAULT_CANVAS
+
+// GridGeometry describes the shape of the canvas: Cols x Rows pages, each
+// holding ButtonsPerPage buttons. It is read from the canvas and
+// grid_geometry tables at startup. ReadOnly is set on canvases archived at
+// the end of a season.
+//
+// Global button ids are numbered IdCols pages to a row. IdCols is fixed when
+// the canvas is created, so ids stay the same when columns are added up to it.
+type GridGeometry struct {
+	Cols           int64 `json:"cols"`
+	Rows           int64 `json:"rows"`
+	IdCols         int64 `json:"id_cols"`
+	ButtonsPerPage int64 `json:"buttons_per_page"`
+	ReadOnly       bool  `json:"read_only"`
+	Season         int64 `json:"season"`
+}
+
+func (g *GridGeometry) Validate() error {
+	if g.Cols <= 0 || g.Rows <= 0 || g.ButtonsPerPage <= 0 {
+		return errors.New("grid geometry must be positive")
+	}
+
+	if g.IdCols < g.Cols {
+		return errors.New("grid has more columns than button ids")
+	}
+
+	return nil
+}
+
+// ButtonsPerRow is the id stride of one row of pages.
+func (g *GridGeometry) ButtonsPerRow() int64 {
+	return g.IdCols * g.ButtonsPerPage
+}
+
+// PageBytes is the length of the raw RGB state of a single page.
+func (g *GridGeometry) PageBytes() int64 {
+	return 3 * g.ButtonsPerPage
+}
+
+func (g *GridGeometry) ButtonLocationToIndex(x int64, y int64, id int64) (int64, error) {
+	offset := ((y - 1) * g.ButtonsPerRow()) + ((x - 1) * g.ButtonsPerPage)
+	ix := id - offset
+
+	if ix < 0 || ix > g.ButtonsPerPage-1 {
+		return -1, errors.New("asked for button on incorrect page")
+	}
+
+	return ix, nil
+}
+
+// ButtonIdToLocation is the inverse of ButtonLocationToIndex, turning a global
+// button id into its page coordinate and in-page index.
+func (g *GridGeometry) ButtonIdToLocation(id int64) (x int64, y int64, ix int64, err error) {
+	if id < 0 || id >= g.Rows*g.ButtonsPerRow() {
+		return -1, -1, -1, errors.New("button id is outside of the grid")
+	}
+
+	y = id/g.ButtonsPerRow() + 1
+	x = (id%g.ButtonsPerRow())/g.ButtonsPerPage + 1
+	ix = id % g.ButtonsPerPage
+
+	// Ids reserved for columns the grid has not grown to yet
+	if x > g.Cols {
+		return -1, -1, -1, errors.New("button id is outside of the grid")
+	}
+
+	return x, y, ix, nil
+}
+
+func (g *GridGeometry) CreateGridPage(x int64, y int64, data []byte) *GridPage {
+	buttonState := make([]ButtonState, g.ButtonsPerPage)
+
+	rowIx := x - 1
+	colIx := y - 1
+
+	for i := range buttonState {
+		id := colIx*g.ButtonsPerRow() + (rowIx * g.ButtonsPerPage) + int64(i)
+		buttonState[i] = ButtonState{
+			id: id,
+			r:  data[i*3],
+			g:  data[(i*3)+1],
+			b:  data[(i*3)+2],
+		}
+	}
+
+	return &GridPage{
+		X:       x,
+		Y:       y,
+		Buttons: buttonState,
+		grid:    g,
+	}
+}
+
+// Contains reports whether (x, y) is a page coordinate inside the grid.
+func (g *GridGeometry) Contains(x int64, y int64) bool {
+	return x >= 1 && y >= 1 && x <= g.Cols && y <= g.Rows
+}
+
+// GridSource holds the current geometry of every canvas, which can grow or
+// change while the app is serving. Readers should look a canvas up once per
+// request and use that value.
+type GridSource struct {
+	canvases atomic.Pointer[map[string]*GridGeometry]
+}
+
+func NewGridSource(canvases map[string]*GridGeometry) *GridSource {
+	source := &GridSource{}
+	source.canvases.Store(&canvases)
+	return source
+}
+
+// Current returns the geometry of a canvas, or nil if it does not exist.
+func (s *GridSource) Current(canvas string) *GridGeometry {
+	return (*s.canvases.Load())[canvas]
+}
+
+func (s *GridSource) Canvases() []string {
+	return slices.Sorted(maps.Keys(*s.canvases.Load()))
+}
+
+func (s *GridSource) Refresh(db ObbDb) error {
+	canvases, err := db.GetCanvases()
+
+	if err != nil {
+		return err
+	}
+
+	old := *s.canvases.Load()
+
+	for name, grid := range canvases {
+		if prev, ok := old[name]; !ok {
+			log.Printf("canvas %s added with %dx%d pages", name, grid.Cols, grid.Rows)
+		} else if *prev != *grid {
+			log.Printf("canvas %s changed from %dx%d to %dx%d pages", name, prev.Cols, prev.Rows, grid.Cols, grid.Rows)
+		}
+	}
+
+	for name := range old {
+		if _, ok := canvases[name]; !ok {
+			log.Printf("canvas %s removed", name)
+		}
+	}
+
+	s.canvases.Store(&canvases)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGridGeometryCustomShape(t *testing.T) {
+	grid := &GridGeometry{Cols: 3, Rows: 2, IdCols: 3, ButtonsPerPage: 4}
+
+	if err := grid.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if grid.PageBytes() != 12 {
+		t.Errorf("expected 12 page bytes, got %d", grid.PageBytes())
+	}
+
+	// Every id in the grid must round trip through both mappings
+	for id := int64(0); id < grid.Cols*grid.Rows*grid.ButtonsPerPage; id++ {
+		x, y, ix, err := grid.ButtonIdToLocation(id)
+
+		if err != nil {
+			t.Fatalf("unexpected error for id %d: %v", id, err)
+		}
+
+		if x < 1 || x > grid.Cols || y < 1 || y > grid.Rows {
+			t.Errorf("id %d mapped outside the grid to (%d, %d)", id, x, y)
+		}
+
+		fwd, err := grid.ButtonLocationToIndex(x, y, id)
+		if err != nil || fwd != ix {
+			t.Errorf("id %d: expected index %d, got %d (%v)", id, ix, fwd, err)
+		}
+
+		page := grid.CreateGridPage(x, y, make([]byte, grid.PageBytes()))
+		if page.Buttons[ix].id != id {
+			t.Errorf("id %d: page (%d, %d) has id %d at index %d", id, x, y, page.Buttons[ix].id, ix)
+		}
+	}
+
+	if _, _, _, err := grid.ButtonIdToLocation(24); err == nil {
+		t.Errorf("expected error for id past the end of the grid")
+	}
+
+	page := grid.CreateGridPage(2, 2, make([]byte, grid.PageBytes()))
+	if len(page.EncodeStates()) != 12 {
+		t.Errorf("expected 12 encoded bytes, got %d", len(page.EncodeStates()))
+	}
+}
+
+func TestGridGeometryIdsSurviveExpansion(t *testing.T) {
+	before := &GridGeometry{Cols: 2, Rows: 2, IdCols: 4, ButtonsPerPage: 4}
+	after := &GridGeometry{Cols: 4, Rows: 3, IdCols: 4, ButtonsPerPage: 4}
+
+	for y := int64(1); y <= before.Rows; y++ {
+		for x := int64(1); x <= before.Cols; x++ {
+			old := before.CreateGridPage(x, y, make([]byte, before.PageBytes()))
+			grown := after.CreateGridPage(x, y, make([]byte, after.PageBytes()))
+
+			for ix := range old.Buttons {
+				if old.Buttons[ix].id != grown.Buttons[ix].id {
+					t.Errorf("page (%d, %d) index %d changed id from %d to %d", x, y, ix, old.Buttons[ix].id, grown.Buttons[ix].id)
+				}
+			}
+		}
+	}
+
+	// Ids reserved for the third column do not exist before the expansion
+	if _, _, _, err := before.ButtonIdToLocation(8); err == nil {
+		t.Error("expected error for an id of a column the grid has not grown to")
+	}
+
+	if x, y, ix, err := after.ButtonIdToLocation(8); err != nil || x != 3 || y != 1 || ix != 0 {
+		t.Errorf("expected id 8 at (3, 1) index 0, got (%d, %d) index %d (%v)", x, y, ix, err)
+	}
+}
+
+func TestGridGeometryValidate(t *testing.T) {
+	invalid := []GridGeometry{
+		{Cols: 0, Rows: 1, IdCols: 1, ButtonsPerPage: 1},
+		{Cols: 1, Rows: -1, IdCols: 1, ButtonsPerPage: 1},
+		{Cols: 1, Rows: 1, IdCols: 1, ButtonsPerPage: 0},
+		{Cols: 2, Rows: 1, IdCols: 1, ButtonsPerPage: 1},
+	}
+
+	for _, grid := range invalid {
+		if err := grid.Validate(); err == nil {
+			t.Errorf("expected error for %+v", grid)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/cmcquillan/one-billion-buttons/dblib"
+	"github.com/gin-gonic/gin"
+)
+
+type AdminApi struct {
+	Database ObbDb
+	Locker   dblib.Lock
+	Grid     *GridSource
+	Config   *Config
+}
+
+type StartSeasonDto struct {
+	Canvas string `json:"canvas"`
+}
+
+type ExpandGridDto struct {
+	Canvas string `json:"canvas"`
+	Cols   int64  `json:"cols"`
+	Rows   int64  `json:"rows"`
+}
+
+// RequireAdminToken rejects requests without the configured bearer token. All
+// admin routes are unavailable when no token is configured.
+func (api *AdminApi) RequireAdminToken(c *gin.Context) {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+	if len(api.Config.AdminToken) == 0 ||
+		!found ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(api.Config.AdminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Admin token required",
+		})
+
+		return
+	}
+
+	c.Next()
+}
+
+func (api *AdminApi) HandleExpandGrid(c *gin.Context) {
+	dto := ExpandGridDto{}
+	err := c.BindJSON(&dto)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	if len(dto.Canvas) == 0 {
+		dto.Canvas = DEFAULT_CANVAS
+	}
+
+	grid := api.Grid.Current(dto.Canvas)
+
+	if grid == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Canvas not found",
+		})
+
+		return
+	}
+
+	if grid.ReadOnly {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Canvas is archived and read only",
+		})
+
+		return
+	}
+
+	if dto.Cols < 0 || dto.Rows < 0 || dto.Cols+dto.Rows == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Expansion must add a positive number of columns or rows",
+		})
+
+		return
+	}
+
+	if grid.Cols+dto.Cols > grid.IdCols {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Canvas has button ids for %d columns", grid.IdCols),
+		})
+
+		return
+	}
+
+	lockVal, err := api.Locker.AcquireLock(dblib.GRID_EXPAND_LOCK_TYPE, api.Config.GridExpandLockTimeout)
+
+	if err == dblib.ErrLockNotAcquired {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "A grid expansion is already running",
+		})
+
+		return
+	}
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Could not start grid expansion",
+		})
+
+		return
+	}
+
+	// Expansion can outlive the request
+	go func() {
+		defer api.Locker.ReleaseLock(lockVal)
+
+		log.Printf("expanding canvas %s by %d columns and %d rows", dto.Canvas, dto.Cols, dto.Rows)
+
+		if err := api.Database.ExpandGrid(dto.Canvas, dto.Cols, dto.Rows); err != nil {
+			log.Printf("grid expansion failed: %v", err)
+			return
+		}
+
+		if err := api.Grid.Refresh(api.Database); err != nil {
+			log.Printf("could not refresh grid geometry: %v", err)
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, api.Grid.Current(dto.Canvas))
+}
+
+// HandleStartSeason archives the current state of a canvas as a read-only
+// season and resets the canvas to blank.
+func (api *AdminApi) HandleStartSeason(c *gin.Context) {
+	dto := StartSeasonDto{}
+	err := c.BindJSON(&dto)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	if len(dto.Canvas) == 0 {
+		dto.Canvas = DEFAULT_CANVAS
+	}
+
+	grid := api.Grid.Current(dto.Canvas)
+
+	if grid == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Canvas not found",
+		})
+
+		return
+	}
+
+	if grid.ReadOnly {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Canvas is archived and read only",
+		})
+
+		return
+	}
+
+	log.Printf("ending season of canvas %s", dto.Canvas)
+
+	err = api.Database.StartSeason(dto.Canvas)
+
+	if errors.Is(err, ErrSeasonArchiveName) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	if err != nil {
+		log.Printf("could not start new season: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Could not start new season",
+		})
+
+		return
+	}
+
+	if err := api.Grid.Refresh(api.Database); err != nil {
+		log.Printf("could not refresh grid geometry: %v", err)
+	}
+
+	seasons, err := api.Database.GetSeasons(dto.Canvas)
+
+	if err != nil || len(seasons) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Could not retrieve seasons",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusCreated, seasons[len(seasons)-1])
+}
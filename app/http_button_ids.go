@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (api *ButtonApi) HandleGetButtonById(c *gin.Context) {
+	canvas, grid, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	_, xCoord, yCoord, ix, found := parseGlobalButtonId(c, grid)
+
+	if !found {
+		return
+	}
+
+	dto, err := retrieveAndMapGridCoordinate(api.Database, canvas, grid, xCoord, yCoord, -1, c.Request)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "We are not available",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, GlobalButtonDto{
+		X:              xCoord,
+		Y:              yCoord,
+		ButtonStateDto: dto.Buttons[ix],
+	})
+}
+
+func (api *ButtonApi) HandlePostButtonById(c *gin.Context) {
+	canvas, grid, ok := resolveWritableCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	id, xCoord, yCoord, ix, found := parseGlobalButtonId(c, grid)
+
+	if !found {
+		return
+	}
+
+	dto := ButtonStateDto{}
+	err := c.BindJSON(&dto)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	rgb, err := HexToBytes(dto.Hex)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	pageDto, res, err := api.pressButton(canvas, grid, xCoord, yCoord, ix, id, rgb, c.Request)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Could not complete request",
+		})
+
+		return
+	}
+
+	c.JSON(res, GlobalButtonDto{
+		X:              xCoord,
+		Y:              yCoord,
+		ButtonStateDto: pageDto.Buttons[ix],
+	})
+}
+
+func parseGlobalButtonId(c *gin.Context, grid *GridGeometry) (id int64, xCoord int64, yCoord int64, ix int64, ok bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Could not extract button id",
+		})
+
+		return
+	}
+
+	xCoord, yCoord, ix, err = grid.ButtonIdToLocation(id)
+
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
+	return id, xCoord, yCoord, ix, true
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	b64 "encoding/base64"
+	"errors"
 	"net/http"
 	url "net/url"
 	"strconv"
@@ -9,12 +10,69 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+const MIMEPageBinary = "application/octet-stream"
+
 type ButtonApi struct {
 	Database     ObbDb
+	Grid         *GridSource
 	EventChannel chan BackgroundButtonEvent
 }
 
+// resolveCanvas looks up the canvas named in the route, or the default canvas
+// for unscoped routes, writing a 404 if it does not exist.
+func resolveCanvas(c *gin.Context, grids *GridSource) (string, *GridGeometry, bool) {
+	canvas := c.Param("canvas")
+
+	if len(canvas) == 0 {
+		canvas = DEFAULT_CANVAS
+	}
+
+	grid := grids.Current(canvas)
+
+	if grid == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Canvas not found",
+		})
+
+		return canvas, nil, false
+	}
+
+	return canvas, grid, true
+}
+
+// resolveWritableCanvas is resolveCanvas for routes that press buttons,
+// additionally refusing canvases archived at the end of a season.
+func resolveWritableCanvas(c *gin.Context, grids *GridSource) (string, *GridGeometry, bool) {
+	canvas, grid, ok := resolveCanvas(c, grids)
+
+	if ok && grid.ReadOnly {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Canvas is archived and read only",
+		})
+
+		return canvas, grid, false
+	}
+
+	return canvas, grid, ok
+}
+
+func (api *ButtonApi) HandleGetGridGeometry(c *gin.Context) {
+	_, grid, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, grid)
+}
+
 func (api *ButtonApi) HandleGetButtonPage(c *gin.Context) {
+	canvas, grid, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
 	xCoord, errX := strconv.ParseInt(c.Param("x"), 10, 64)
 	yCoord, errY := strconv.ParseInt(c.Param("y"), 10, 64)
 
@@ -34,18 +92,81 @@ func (api *ButtonApi) HandleGetButtonPage(c *gin.Context) {
 		return
 	}
 
-	dto, err := retrieveAndMapGridCoordinate(api.Database, xCoord, yCoord, c.Request)
+	if !grid.Contains(xCoord, yCoord) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Coordinate is outside of the grid",
+		})
+
+		return
+	}
+
+	since := int64(-1)
+
+	if v := c.Query("since"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Could not extract version",
+			})
+
+			return
+		}
+
+		since = parsed
+	}
+
+	c.Header("Vary", "Accept")
+
+	if c.NegotiateFormat(gin.MIMEJSON, MIMEPageBinary) == MIMEPageBinary {
+		api.writeButtonPageBinary(c, canvas, grid, xCoord, yCoord, since)
+		return
+	}
+
+	dto, err := retrieveAndMapGridCoordinate(api.Database, canvas, grid, xCoord, yCoord, since, c.Request)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "We are not available",
 		})
+
+		return
+	}
+
+	if since >= 0 && dto.Version <= since {
+		c.Status(http.StatusNotModified)
+		return
 	}
 
 	c.JSON(http.StatusOK, dto)
 }
 
+func (api *ButtonApi) writeButtonPageBinary(c *gin.Context, canvas string, grid *GridGeometry, xCoord int64, yCoord int64, since int64) {
+	state, version, err := api.Database.GetPageButtonState(canvas, xCoord, yCoord)
+
+	if err != nil || int64(len(state)) != grid.PageBytes() {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "We are not available",
+		})
+
+		return
+	}
+
+	if since >= 0 && version <= since {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, MIMEPageBinary, EncodePageBinary(xCoord, yCoord, version, state))
+}
+
 func (api *ButtonApi) HandlePostButton(c *gin.Context) {
+	canvas, grid, ok := resolveWritableCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
 	xCoord, errX := strconv.ParseInt(c.Param("x"), 10, 64)
 	yCoord, errY := strconv.ParseInt(c.Param("y"), 10, 64)
 
@@ -68,7 +189,15 @@ func (api *ButtonApi) HandlePostButton(c *gin.Context) {
 		return
 	}
 
-	ix, err := ButtonLocationToIndex(xCoord, yCoord, dto.ID)
+	if !grid.Contains(xCoord, yCoord) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Coordinate is outside of the grid",
+		})
+
+		return
+	}
+
+	ix, err := grid.ButtonLocationToIndex(xCoord, yCoord, dto.ID)
 
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -88,7 +217,7 @@ func (api *ButtonApi) HandlePostButton(c *gin.Context) {
 		return
 	}
 
-	err = api.Database.SetButtonState(xCoord, yCoord, ix, rgb)
+	bDto, res, err := api.pressButton(canvas, grid, xCoord, yCoord, ix, dto.ID, rgb, c.Request)
 
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -98,45 +227,104 @@ func (api *ButtonApi) HandlePostButton(c *gin.Context) {
 		return
 	}
 
-	bDto, _ := retrieveAndMapGridCoordinate(api.Database, xCoord, yCoord, c.Request)
+	c.JSON(res, bDto)
+}
+
+// pressButton applies a press and returns the resulting page along with
+// http.StatusOK if the press won, or http.StatusConflict if the button had
+// already been pressed.
+func (api *ButtonApi) pressButton(canvas string, grid *GridGeometry, xCoord int64, yCoord int64, ix int64, id int64, rgb []byte, r *http.Request) (*GridPageDto, int, error) {
+	err := api.Database.SetButtonState(canvas, xCoord, yCoord, ix, rgb)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	bDto, err := retrieveAndMapGridCoordinate(api.Database, canvas, grid, xCoord, yCoord, -1, r)
+
+	if err != nil {
+		return nil, 0, err
+	}
 
 	button := bDto.Buttons[ix]
 
 	res := http.StatusConflict
 
-	if HexCodesAreEquivalent(button.Hex, dto.Hex) {
+	if HexCodesAreEquivalent(button.Hex, ToHex(rgb)) {
 		res = http.StatusOK
 
 		api.EventChannel <- BackgroundButtonEvent{
-			X:     uint64(xCoord),
-			Y:     uint64(yCoord),
-			ID:    dto.ID,
-			Event: ButtonEventTypePress,
+			Canvas: canvas,
+			X:      uint64(xCoord),
+			Y:      uint64(yCoord),
+			ID:     id,
+			Event:  ButtonEventTypePress,
 		}
 	}
 
-	c.JSON(res, bDto)
+	return bDto, res, nil
 }
 
-func retrieveAndMapGridCoordinate(db ObbDb, xCoord int64, yCoord int64, r *http.Request) (*GridPageDto, error) {
-	state, err := db.GetPageButtonState(xCoord, yCoord)
+// retrieveAndMapGridCoordinate maps a page to its DTO. When since is not
+// negative only the buttons pressed after that page version are included.
+func retrieveAndMapGridCoordinate(db ObbDb, canvas string, grid *GridGeometry, xCoord int64, yCoord int64, since int64, r *http.Request) (*GridPageDto, error) {
+	state, version, err := db.GetPageButtonState(canvas, xCoord, yCoord)
 
 	if err != nil {
 		return nil, err
 	}
 
-	page := CreateGridPage(xCoord, yCoord, state)
+	if int64(len(state)) != grid.PageBytes() {
+		return nil, errors.New("page state does not match grid geometry")
+	}
+
+	page := grid.CreateGridPage(xCoord, yCoord, state)
+
+	withTimes := r.URL.Query().Get("times") == "true"
+
+	if withTimes {
+		times, err := db.GetPagePressTimes(canvas, xCoord, yCoord)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := page.ApplyPressTimes(times); err != nil {
+			return nil, err
+		}
+	}
+
+	buttons := page.Buttons
+
+	if since >= 0 {
+		buttons = []ButtonState{}
+
+		if version > since {
+			versions, err := db.GetPagePressVersions(canvas, xCoord, yCoord)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if err := page.ApplyPressVersions(versions); err != nil {
+				return nil, err
+			}
+
+			buttons = page.ChangedSince(since, version)
+		}
+	}
 
-	data := make([]ButtonStateDto, len(page.Buttons))
+	data := make([]ButtonStateDto, len(buttons))
 
-	for i, dx := 0, 0; i < len(data); i, dx = i+1, dx+3 {
+	for i := range data {
 		var b string = ""
-		if !page.Buttons[i].IsEmpty() {
-			b = page.Buttons[i].ToHex()
+		if !buttons[i].IsEmpty() {
+			b = buttons[i].ToHex()
 		}
 		data[i] = ButtonStateDto{
-			Hex: b,
-			ID:  page.Buttons[i].id,
+			Hex:       b,
+			ID:        buttons[i].id,
+			PressedAt: buttons[i].pressedAt,
 		}
 	}
 
@@ -152,6 +340,7 @@ func retrieveAndMapGridCoordinate(db ObbDb, xCoord int64, yCoord int64, r *http.
 	dto := &GridPageDto{
 		X:       xCoord,
 		Y:       yCoord,
+		Version: version,
 		Buttons: data,
 		Next:    nextUri.String(),
 	}
@@ -14,7 +14,16 @@ type CursorApi struct {
 }
 
 func (api *CursorApi) GetCursor(c *gin.Context) {
-	hex := c.Param("hex")
+	rgb, err := ParseColor(c.Param("hex"))
+
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+
+		return
+	}
+
 	reader, err := os.Open("static/cursor.png")
 
 	if err != nil {
@@ -33,12 +42,7 @@ func (api *CursorApi) GetCursor(c *gin.Context) {
 		return
 	}
 
-	cursor, err := ColorizeImage(img, hex)
-
-	if err != nil {
-		log.Fatal(err)
-		c.Status(http.StatusInternalServerError)
-	}
+	cursor := ColorizeImage(img, rgb)
 
 	errWr := png.Encode(c.Writer, cursor)
 
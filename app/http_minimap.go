@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
+	"github.com/gin-gonic/gin"
+)
+
+type MinimapApi struct {
+	Grid           *GridSource
+	Store          minimaplib.MinimapStore
+	Minimaps       *MinimapCache
+	Formats        []minimaplib.MinimapFormat
+	MaxAge         time.Duration
+	HeatmapWindows []time.Duration
+	Diffs          *DiffCache
+}
+
+// serveStored serves an image from the minimap store and reports whether it
+// was there. Store failures are answered here.
+func (api *MinimapApi) serveStored(c *gin.Context, name string) bool {
+	obj, err := api.Store.Get(c.Request.Context(), name)
+
+	if err == minimaplib.ErrNotFound {
+		return false
+	}
+
+	if err != nil {
+		log.Printf("could not read %s from the minimap store: %v", name, err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Could not read minimap",
+		})
+
+		return true
+	}
+
+	c.Data(http.StatusOK, "image/png", obj.Data)
+	return true
+}
+
+// negotiateFormat picks the first drawn format the Accept header allows.
+func (api *MinimapApi) negotiateFormat(c *gin.Context) (minimaplib.MinimapFormat, bool) {
+	offered := make([]string, len(api.Formats))
+
+	for i, format := range api.Formats {
+		offered[i] = format.ContentType()
+	}
+
+	i := slices.Index(offered, c.NegotiateFormat(offered...))
+
+	if i < 0 {
+		return "", false
+	}
+
+	return api.Formats[i], true
+}
+
+// HandleGetMinimap serves the minimap in the format named by the extension of
+// the route, or negotiated from the Accept header on /minimap.
+func (api *MinimapApi) HandleGetMinimap(c *gin.Context) {
+	canvas, _, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	format := minimaplib.MinimapFormat(strings.TrimPrefix(path.Ext(c.FullPath()), "."))
+
+	if len(format) == 0 {
+		c.Header("Vary", "Accept")
+
+		if format, ok = api.negotiateFormat(c); !ok {
+			c.Status(http.StatusNotAcceptable)
+			return
+		}
+	}
+
+	if !slices.Contains(api.Formats, format) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	entry, err := api.Minimaps.Get(canvas, format, c.Request.Context())
+
+	if err == minimaplib.ErrNotFound {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		log.Printf("could not read %s minimap: %v", canvas, err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Could not read minimap",
+		})
+
+		return
+	}
+
+	// Clients revalidate once a newer minimap may have been drawn
+	c.Header("Content-Type", format.ContentType())
+	c.Header("ETag", entry.etag)
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(api.MaxAge.Seconds())))
+
+	http.ServeContent(c.Writer, c.Request, minimaplib.MinimapName(canvas, format), entry.info.GeneratedAt, bytes.NewReader(entry.data))
+}
+
+func (api *MinimapApi) HandleGetMinimapInfo(c *gin.Context) {
+	canvas, _, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	entry, err := api.Minimaps.Get(canvas, minimaplib.MINIMAP_PNG, c.Request.Context())
+
+	if err == minimaplib.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Minimap has not been drawn yet",
+		})
+
+		return
+	}
+
+	if err != nil {
+		log.Printf("could not read %s minimap: %v", canvas, err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Could not read minimap",
+		})
+
+		return
+	}
+
+	c.JSON(http.StatusOK, entry.info)
+}
+
+// HandleGetHeatmap serves the press heatmap over ?window, one of the
+// configured windows. The first one is the default.
+func (api *MinimapApi) HandleGetHeatmap(c *gin.Context) {
+	canvas, _, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	if len(api.HeatmapWindows) == 0 {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	window := api.HeatmapWindows[0]
+
+	if param, ok := c.GetQuery("window"); ok {
+		i := slices.IndexFunc(api.HeatmapWindows, func(w time.Duration) bool {
+			return HeatmapWindowName(w) == param
+		})
+
+		if i < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Unknown heatmap window",
+			})
+
+			return
+		}
+
+		window = api.HeatmapWindows[i]
+	}
+
+	if !api.serveStored(c, HeatmapName(canvas, window)) {
+		c.Status(http.StatusNotFound)
+	}
+}
+
+var emptyTile = encodeEmptyTile()
+
+func encodeEmptyTile() []byte {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, MINIMAP_TILE_SIZE, MINIMAP_TILE_SIZE)))
+	return buf.Bytes()
+}
+
+// HandleGetMinimapTiles describes the tile pyramid served by HandleGetMinimapTile.
+func (api *MinimapApi) HandleGetMinimapTiles(c *gin.Context) {
+	_, grid, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, NewTileLayout(grid))
+}
+
+func (api *MinimapApi) HandleGetMinimapTile(c *gin.Context) {
+	canvas, grid, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	z, errZ := strconv.Atoi(c.Param("z"))
+	x, errX := strconv.ParseInt(c.Param("x"), 10, 64)
+	yParam, isPng := strings.CutSuffix(c.Param("y"), ".png")
+	y, errY := strconv.ParseInt(yParam, 10, 64)
+
+	if errZ != nil || errX != nil || errY != nil || !isPng {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Could not extract tile coordinate",
+		})
+
+		return
+	}
+
+	if !NewTileLayout(grid).Contains(z, x, y) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Tile is outside of the map",
+		})
+
+		return
+	}
+
+	c.Header("Cache-Control", "max-age=60, public")
+
+	// Tiles without a single pressed button are never written
+	if !api.serveStored(c, MinimapTileName(canvas, z, x, y)) {
+		c.Data(http.StatusOK, "image/png", emptyTile)
+	}
+}
+
+// SNAPSHOT_MAX_AGE is the Cache-Control max-age of responses that only
+// depend on archived snapshots, which never change.
+const SNAPSHOT_MAX_AGE = 365 * 24 * time.Hour
+
+// readSnapshotIndex reads the snapshot index of a canvas and answers store
+// failures itself.
+func (api *MinimapApi) readSnapshotIndex(c *gin.Context, canvas string) (*minimaplib.SnapshotIndex, bool) {
+	idx, err := minimaplib.ReadSnapshotIndex(api.Store, canvas, c.Request.Context())
+
+	if err != nil {
+		log.Printf("could not read %s minimap snapshots: %v", canvas, err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Could not read minimap snapshots",
+		})
+
+		return nil, false
+	}
+
+	return idx, true
+}
+
+// HandleGetMinimapSnapshots lists the archived minimaps of a canvas, oldest
+// first.
+func (api *MinimapApi) HandleGetMinimapSnapshots(c *gin.Context) {
+	canvas, _, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	idx, ok := api.readSnapshotIndex(c, canvas)
+
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "max-age=60, public")
+	c.JSON(http.StatusOK, idx)
+}
+
+func (api *MinimapApi) HandleGetMinimapSnapshot(c *gin.Context) {
+	canvas, _, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	id, isPng := strings.CutSuffix(c.Param("snapshot"), ".png")
+
+	if !isPng {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Could not extract snapshot id",
+		})
+
+		return
+	}
+
+	c.Header("Cache-Control", fmt.Sprintf("max-age=%d, public, immutable", int(SNAPSHOT_MAX_AGE.Seconds())))
+
+	if !api.serveStored(c, minimaplib.SnapshotName(canvas, id)) {
+		c.Header("Cache-Control", "no-store")
+
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Snapshot not found",
+		})
+	}
+}
+
+// HandleGetMinimapDiff highlights the pages that changed between snapshot
+// ?from and snapshot ?to, the latest one by default. ?since=12h starts from
+// the latest snapshot taken at least that long ago instead of ?from. Both are
+// resolved to snapshot ids and redirected to, so only named diffs render.
+func (api *MinimapApi) HandleGetMinimapDiff(c *gin.Context) {
+	canvas, _, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	idx, ok := api.readSnapshotIndex(c, canvas)
+
+	if !ok {
+		return
+	}
+
+	to := idx.Latest()
+	var from *minimaplib.Snapshot
+
+	toId, namedTo := c.GetQuery("to")
+	fromId, namedFrom := c.GetQuery("from")
+
+	if namedTo {
+		to = idx.Find(toId)
+	}
+
+	if namedFrom {
+		from = idx.Find(fromId)
+	} else if param, ok := c.GetQuery("since"); ok {
+		since, err := time.ParseDuration(param)
+
+		if err != nil || since <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "since must be a positive duration",
+			})
+
+			return
+		}
+
+		from = idx.Before(time.Now().Add(-since))
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Either from or since is required",
+		})
+
+		return
+	}
+
+	if from == nil || to == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Snapshot not found",
+		})
+
+		return
+	}
+
+	// Named snapshots make an immutable URL, which every client and cache shares
+	if !namedFrom || !namedTo {
+		query := url.Values{"from": {from.Id}, "to": {to.Id}}
+
+		c.Header("Cache-Control", fmt.Sprintf("max-age=%d, public", int(api.MaxAge.Seconds())))
+		c.Redirect(http.StatusFound, c.Request.URL.Path+"?"+query.Encode())
+
+		return
+	}
+
+	data, changed, err := api.renderSnapshotDiff(canvas, from, to, c.Request.Context())
+
+	if err != nil {
+		log.Printf("could not diff %s minimap snapshots %s and %s: %v", canvas, from.Id, to.Id, err)
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Could not diff minimap snapshots",
+		})
+
+		return
+	}
+
+	c.Header("Cache-Control", fmt.Sprintf("max-age=%d, public, immutable", int(SNAPSHOT_MAX_AGE.Seconds())))
+	c.Header("X-Snapshot-From", from.Id)
+	c.Header("X-Snapshot-To", to.Id)
+	c.Header("X-Changed-Pages", strconv.Itoa(changed))
+
+	c.Data(http.StatusOK, "image/png", data)
+}
+
+// renderSnapshotDiff encodes the diff of two snapshots and counts the pages
+// that changed. Diffs are rendered once and served from api.Diffs after.
+func (api *MinimapApi) renderSnapshotDiff(canvas string, from *minimaplib.Snapshot, to *minimaplib.Snapshot, ctx context.Context) ([]byte, int, error) {
+	if cached := api.Diffs.Get(canvas, from, to); cached != nil {
+		return cached.data, cached.changed, nil
+	}
+
+	images := make([]image.Image, 2)
+
+	for i, snapshot := range []*minimaplib.Snapshot{from, to} {
+		obj, err := api.Store.Get(ctx, minimaplib.SnapshotName(canvas, snapshot.Id))
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if images[i], err = png.Decode(bytes.NewReader(obj.Data)); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	diff, changed := minimaplib.RenderDiff(images[0], images[1])
+	buf := bytes.Buffer{}
+
+	if err := png.Encode(&buf, diff); err != nil {
+		return nil, 0, err
+	}
+
+	api.Diffs.Put(canvas, from, to, &cachedDiff{data: buf.Bytes(), changed: changed})
+	return buf.Bytes(), changed, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SeasonDto describes a canvas archived at the end of a season. The archive
+// is itself a read-only canvas served under /api/c/{canvas}.
+type SeasonDto struct {
+	Canvas     string    `json:"canvas"`
+	Season     int64     `json:"season"`
+	Cols       int64     `json:"cols"`
+	Rows       int64     `json:"rows"`
+	ArchivedAt time.Time `json:"archived_at"`
+}
+
+type SeasonApi struct {
+	Database ObbDb
+	Grid     *GridSource
+}
+
+func (api *SeasonApi) HandleGetSeasons(c *gin.Context) {
+	canvas, _, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	seasons, err := api.Database.GetSeasons(canvas)
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Could not retrieve seasons",
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "max-age=120, public")
+	c.JSON(http.StatusOK, seasons)
+}
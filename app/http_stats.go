@@ -8,10 +8,17 @@ import (
 
 type StatsApi struct {
 	Database ObbDb
+	Grid     *GridSource
 }
 
 func (api *StatsApi) HandleGetButtonStats(c *gin.Context) {
-	stats, err := api.Database.GetButtonStats()
+	canvas, _, ok := resolveCanvas(c, api.Grid)
+
+	if !ok {
+		return
+	}
+
+	stats, err := api.Database.GetButtonStats(canvas)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
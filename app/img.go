@@ -5,15 +5,10 @@ import (
 	"image/color"
 )
 
-func ColorizeImage(img image.Image, hex string) (image.Image, error) {
+func ColorizeImage(img image.Image, newRgb []byte) image.Image {
 	bounds := img.Bounds()
 
 	new := image.NewRGBA(bounds)
-	newRgb, err := HexToBytes(hex)
-
-	if err != nil {
-		return nil, err
-	}
 
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
@@ -45,5 +40,5 @@ func ColorizeImage(img image.Image, hex string) (image.Image, error) {
 	}
 
 	sub := new.SubImage(bounds)
-	return sub, nil
+	return sub
 }
@@ -9,6 +9,7 @@ import (
 	"syscall"
 
 	"github.com/cmcquillan/one-billion-buttons/dblib"
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
 	"github.com/gin-gonic/gin"
 )
 
@@ -38,18 +39,66 @@ func main() {
 	}
 
 	mmDb := &MinimapDbSql{
-		connStr: cfg.PgConnectionString,
+		MinimapDbSql: minimaplib.MinimapDbSql{ConnStr: cfg.PgConnectionString},
+	}
+
+	store, err := minimaplib.NewStore(&cfg.StoreConfig)
+
+	if err != nil {
+		log.Fatalf("could not create minimap store: %v", err)
+	}
+
+	minimaps := NewMinimapCache(store, MinimapInterval(cfg))
+
+	schema := &SchemaState{}
+
+	if cfg.AutoMigrate {
+		if err := MigrateSchema(db, locker, schema, cfg); err != nil {
+			log.Fatalf("could not migrate database schema: %v", err)
+		}
+	}
+
+	if err := schema.Refresh(db); err != nil && cfg.RequireCurrentSchema {
+		log.Fatalf("could not verify database schema: %v", err)
+	}
+
+	if !schema.Current() {
+		if cfg.RequireCurrentSchema {
+			log.Fatalf("%v, run makedb up or set AUTO_MIGRATE", dblib.ErrSchemaBehind)
+		}
+
+		log.Printf("%v, readiness will fail until it is migrated", dblib.ErrSchemaBehind)
+	}
+
+	// Guessing the geometry would misplace every button id, so refuse to start
+	canvases, err := db.GetCanvases()
+
+	if err != nil {
+		log.Fatalf("could not load canvases: %v", err)
+	}
+
+	if canvases[DEFAULT_CANVAS] == nil {
+		log.Fatalf("canvas %s does not exist, run makedb up", DEFAULT_CANVAS)
+	}
+
+	for name, geometry := range canvases {
+		log.Printf("canvas %s is %dx%d pages of %d buttons", name, geometry.Cols, geometry.Rows, geometry.ButtonsPerPage)
 	}
 
+	grid := NewGridSource(canvases)
+
 	ctx, cancel := context.WithCancel(context.Background())
 
 	buttonEventChannel := make(chan BackgroundButtonEvent, cfg.ButtonEventChannelSize)
 	go BackgroundEventHandler(db, buttonEventChannel, cfg)
 	go BackgroundComputeStatistics(db, ctx, cfg)
+	go BackgroundRefreshGrid(db, grid, ctx, cfg)
+	go BackgroundCheckSchema(db, schema, ctx, cfg)
 
 	if cfg.RunMinimapInMain {
 		log.Print("Starting minimap generation in main instance")
-		go BackgroundWorkerMinimap(locker, mmDb, ctx, cfg)
+		go BackgroundWorkerMinimap(locker, mmDb, minimaps, grid, ctx, cfg)
+		go BackgroundWorkerHeatmap(locker, mmDb, store, grid, ctx, cfg)
 	}
 
 	router := gin.Default()
@@ -58,14 +107,45 @@ func main() {
 	router.ForwardedByClientIP = true
 	router.SetTrustedProxies(nil)
 
-	buttonApi := ButtonApi{Database: db, EventChannel: buttonEventChannel}
+	buttonApi := ButtonApi{Database: db, Grid: grid, EventChannel: buttonEventChannel}
 	cursorApi := CursorApi{}
 
-	router.POST("/api/:x/:y", buttonApi.HandlePostButton)
+	statsApi := StatsApi{Database: db, Grid: grid}
+	seasonApi := SeasonApi{Database: db, Grid: grid}
+	minimapApi := MinimapApi{
+		Grid:           grid,
+		Store:          store,
+		Minimaps:       minimaps,
+		Formats:        cfg.DrawnFormats(),
+		MaxAge:         MinimapInterval(cfg),
+		HeatmapWindows: cfg.HeatmapWindows,
+		Diffs:          NewDiffCache(SNAPSHOT_DIFF_CACHE_SIZE),
+	}
+
+	// Unscoped routes serve the main canvas, /api/c/:canvas serves any canvas
+	for _, api := range []*gin.RouterGroup{router.Group("/api"), router.Group("/api/c/:canvas")} {
+		api.POST("/:x/:y", buttonApi.HandlePostButton)
+
+		api.GET("/:x/:y", buttonApi.HandleGetButtonPage)
+
+		api.GET("/:x/:y/:hash", buttonApi.HandleGetButtonPage)
+
+		api.GET("/grid", buttonApi.HandleGetGridGeometry)
 
-	router.GET("/api/:x/:y", buttonApi.HandleGetButtonPage)
+		api.GET("/buttons/:id", buttonApi.HandleGetButtonById)
 
-	router.GET("/api/:x/:y/:hash", buttonApi.HandleGetButtonPage)
+		api.POST("/buttons/:id", buttonApi.HandlePostButtonById)
+
+		api.GET("/stats", statsApi.HandleGetButtonStats)
+
+		api.GET("/seasons", seasonApi.HandleGetSeasons)
+
+		api.GET("/minimap/tiles", minimapApi.HandleGetMinimapTiles)
+
+		api.GET("/minimap/info", minimapApi.HandleGetMinimapInfo)
+
+		api.GET("/minimap/snapshots", minimapApi.HandleGetMinimapSnapshots)
+	}
 
 	router.GET("/cursor/:hex/cursor.png", cursorApi.GetCursor)
 
@@ -73,6 +153,8 @@ func main() {
 		ctx.Status(http.StatusOK)
 	})
 
+	router.GET("/healthcheck/ready", schema.HandleReady)
+
 	router.GET("/", func(c *gin.Context) {
 		if pusher := c.Writer.Pusher(); pusher != nil {
 			if err := pusher.Push("/app.js", nil); err != nil {
@@ -88,17 +170,36 @@ func main() {
 		c.File("./static/index.html")
 	})
 
-	router.GET("/minimap.png", func(c *gin.Context) {
-		if _, err := os.Stat("./static/minimap.png"); err == nil {
-			c.File("./static/minimap.png")
-			return
+	// /minimap negotiates the format, /minimap.{ext} names it
+	for _, prefix := range []string{"/minimap", "/c/:canvas/minimap"} {
+		router.GET(prefix, minimapApi.HandleGetMinimap)
+
+		for _, format := range cfg.DrawnFormats() {
+			router.GET(prefix+"."+string(format), minimapApi.HandleGetMinimap)
 		}
+	}
 
-		c.Status(http.StatusNotFound)
-	})
+	router.GET("/heatmap.png", minimapApi.HandleGetHeatmap)
+
+	router.GET("/c/:canvas/heatmap.png", minimapApi.HandleGetHeatmap)
+
+	router.GET("/minimap/:z/:x/:y", minimapApi.HandleGetMinimapTile)
+
+	router.GET("/c/:canvas/minimap/:z/:x/:y", minimapApi.HandleGetMinimapTile)
+
+	router.GET("/minimap/snapshots/:snapshot", minimapApi.HandleGetMinimapSnapshot)
+
+	router.GET("/c/:canvas/minimap/snapshots/:snapshot", minimapApi.HandleGetMinimapSnapshot)
+
+	router.GET("/minimap/diff.png", minimapApi.HandleGetMinimapDiff)
+
+	router.GET("/c/:canvas/minimap/diff.png", minimapApi.HandleGetMinimapDiff)
+
+	adminApi := AdminApi{Database: db, Locker: locker, Grid: grid, Config: cfg}
+	admin := router.Group("/api/admin", adminApi.RequireAdminToken)
+	admin.POST("/grid/expand", adminApi.HandleExpandGrid)
 
-	statsApi := StatsApi{Database: db}
-	router.GET("/api/stats", statsApi.HandleGetButtonStats)
+	admin.POST("/seasons", adminApi.HandleStartSeason)
 
 	router.StaticFile("/app.js", "./static/app.js")
 	router.StaticFile("/style.css", "./static/style.css")
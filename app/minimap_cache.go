@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image/png"
+	"sync"
+	"time"
+
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
+)
+
+type cachedMinimap struct {
+	data      []byte
+	etag      string
+	info      minimaplib.MinimapInfo
+	fetchedAt time.Time
+
+	// Versions in the store, sent to revalidate the copy once it expires
+	modTime       time.Time
+	storeETag     string
+	infoStoreETag string
+}
+
+// minimapFetch is a read of the store in flight. Every Get of the same
+// minimap waits for it rather than reading the store again.
+type minimapFetch struct {
+	done  chan struct{}
+	entry *cachedMinimap
+	err   error
+}
+
+// MinimapCache keeps the latest encoded minimap of every canvas in memory, in
+// every format. The instance drawing minimaps publishes through it, every
+// other instance revalidates its copy with the store once it is older than
+// refresh.
+type MinimapCache struct {
+	Store   minimaplib.MinimapStore
+	refresh time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cachedMinimap
+	fetches map[string]*minimapFetch
+}
+
+func NewMinimapCache(store minimaplib.MinimapStore, refresh time.Duration) *MinimapCache {
+	return &MinimapCache{
+		Store:   store,
+		refresh: refresh,
+		entries: make(map[string]*cachedMinimap),
+		fetches: make(map[string]*minimapFetch),
+	}
+}
+
+// Publish puts freshly encoded minimaps and their info into the store, then
+// serves them from memory.
+func (c *MinimapCache) Publish(canvas string, variants map[minimaplib.MinimapFormat][]byte, info minimaplib.MinimapInfo, ctx context.Context) error {
+	info, err := minimaplib.Publish(c.Store, canvas, variants, info, ctx)
+
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	for format, data := range variants {
+		c.entries[minimaplib.MinimapName(canvas, format)] = &cachedMinimap{data: data, etag: minimaplib.MinimapETag(data), info: info, fetchedAt: now, modTime: now}
+	}
+
+	return nil
+}
+
+// Get returns the latest minimap of a canvas in a format, or
+// minimaplib.ErrNotFound when none was drawn yet. The store is read without
+// holding the lock, at most once at a time per minimap.
+func (c *MinimapCache) Get(canvas string, format minimaplib.MinimapFormat, ctx context.Context) (*cachedMinimap, error) {
+	name := minimaplib.MinimapName(canvas, format)
+
+	c.mu.Lock()
+
+	entry := c.entries[name]
+
+	if entry != nil && time.Since(entry.fetchedAt) < c.refresh {
+		c.mu.Unlock()
+		return entry, nil
+	}
+
+	f := c.fetches[name]
+
+	if f == nil {
+		f = &minimapFetch{done: make(chan struct{})}
+		c.fetches[name] = f
+		c.mu.Unlock()
+
+		f.entry, f.err = c.fetch(canvas, format, entry, ctx)
+
+		c.mu.Lock()
+		delete(c.fetches, name)
+
+		// A minimap published meanwhile is newer than the one read
+		if f.err == nil {
+			if current := c.entries[name]; current != entry {
+				f.entry = current
+			} else {
+				c.entries[name] = f.entry
+			}
+		}
+
+		c.mu.Unlock()
+		close(f.done)
+	} else {
+		c.mu.Unlock()
+
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if f.err != nil {
+		// A store hiccup keeps serving the copy in memory
+		if entry != nil && f.err != minimaplib.ErrNotFound {
+			return entry, nil
+		}
+
+		return nil, f.err
+	}
+
+	return f.entry, nil
+}
+
+// fetch reads a minimap and its info from the store. Objects that did not
+// change since prev was read are kept from prev.
+func (c *MinimapCache) fetch(canvas string, format minimaplib.MinimapFormat, prev *cachedMinimap, ctx context.Context) (*cachedMinimap, error) {
+	entry := &cachedMinimap{}
+
+	if prev != nil {
+		*entry = *prev
+	}
+
+	entry.fetchedAt = time.Now()
+
+	obj, err := c.Store.GetIfNoneMatch(ctx, minimaplib.MinimapName(canvas, format), entry.storeETag)
+
+	if err == nil {
+		entry.data, entry.etag = obj.Data, minimaplib.MinimapETag(obj.Data)
+		entry.modTime, entry.storeETag = obj.ModTime, obj.ETag
+	} else if err != minimaplib.ErrNotModified {
+		return nil, err
+	}
+
+	infoObj, err := c.Store.GetIfNoneMatch(ctx, minimaplib.MinimapInfoName(canvas), entry.infoStoreETag)
+
+	if err == nil {
+		entry.info, entry.infoStoreETag = minimaplib.MinimapInfo{}, infoObj.ETag
+		err = json.Unmarshal(infoObj.Data, &entry.info)
+	} else if err == minimaplib.ErrNotModified {
+		err = nil
+	} else {
+		entry.infoStoreETag = ""
+	}
+
+	// Minimaps published without info, or a PNG with info from another run,
+	// are described from the image itself
+	if err != nil || (format == minimaplib.MINIMAP_PNG && entry.info.ETag != entry.etag) {
+		entry.info = minimaplib.MinimapInfo{Canvas: canvas, GeneratedAt: entry.modTime, Formats: []minimaplib.MinimapFormat{format}}
+
+		if format == minimaplib.MINIMAP_PNG {
+			entry.info.ETag = entry.etag
+
+			if config, err := png.DecodeConfig(bytes.NewReader(entry.data)); err == nil {
+				entry.info.Width, entry.info.Height = int64(config.Width), int64(config.Height)
+			}
+		}
+	}
+
+	return entry, nil
+}
+
+// SNAPSHOT_DIFF_CACHE_SIZE is how many rendered snapshot diffs are kept.
+const SNAPSHOT_DIFF_CACHE_SIZE = 16
+
+type cachedDiff struct {
+	data    []byte
+	changed int
+}
+
+// DiffCache keeps rendered snapshot diffs in memory. Snapshots never change,
+// so a diff is keyed by the ETags of its two snapshots and is only evicted,
+// oldest first, to stay within size.
+type DiffCache struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*cachedDiff
+	order   []string
+}
+
+func NewDiffCache(size int) *DiffCache {
+	return &DiffCache{
+		size:    size,
+		entries: make(map[string]*cachedDiff),
+	}
+}
+
+func diffKey(canvas string, from *minimaplib.Snapshot, to *minimaplib.Snapshot) string {
+	return canvas + " " + from.ETag + " " + to.ETag
+}
+
+func (c *DiffCache) Get(canvas string, from *minimaplib.Snapshot, to *minimaplib.Snapshot) *cachedDiff {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.entries[diffKey(canvas, from, to)]
+}
+
+func (c *DiffCache) Put(canvas string, from *minimaplib.Snapshot, to *minimaplib.Snapshot, diff *cachedDiff) {
+	key := diffKey(canvas, from, to)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+
+	for len(c.order) > 0 && len(c.order) >= c.size {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	c.entries[key] = diff
+	c.order = append(c.order, key)
+}
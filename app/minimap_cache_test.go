@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
+)
+
+func TestMinimapCacheSharesThroughStore(t *testing.T) {
+	ctx := context.Background()
+	store := &minimaplib.LocalStore{Dir: t.TempDir()}
+	drawing := NewMinimapCache(store, time.Minute)
+	serving := NewMinimapCache(store, 0)
+
+	if _, err := serving.Get("main", minimaplib.MINIMAP_PNG, ctx); err != minimaplib.ErrNotFound {
+		t.Fatalf("expected no minimap yet, got %v", err)
+	}
+
+	info := minimaplib.MinimapInfo{Width: 3, Height: 2, PagesScanned: 4, Full: true}
+	variants := map[minimaplib.MinimapFormat][]byte{minimaplib.MINIMAP_PNG: []byte("first"), minimaplib.MINIMAP_BIN: []byte("first bin")}
+
+	if err := drawing.Publish("main", variants, info, ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, err := serving.Get("main", minimaplib.MINIMAP_PNG, ctx)
+
+	if err != nil || string(entry.data) != "first" {
+		t.Fatalf("expected the published minimap, got %v", err)
+	}
+
+	got := entry.info
+
+	if got.Canvas != "main" || got.PagesScanned != 4 || got.ETag != minimaplib.MinimapETag([]byte("first")) {
+		t.Errorf("unexpected info %+v", got)
+	}
+
+	if !slices.Equal(got.Formats, []minimaplib.MinimapFormat{minimaplib.MINIMAP_BIN, minimaplib.MINIMAP_PNG}) {
+		t.Errorf("unexpected formats %v", got.Formats)
+	}
+
+	entry, err = serving.Get("main", minimaplib.MINIMAP_BIN, ctx)
+
+	if err != nil || string(entry.data) != "first bin" || entry.etag != minimaplib.MinimapETag([]byte("first bin")) {
+		t.Fatalf("expected the published binary minimap, got %v", err)
+	}
+
+	if _, err := serving.Get("main", minimaplib.MINIMAP_GIF, ctx); err != minimaplib.ErrNotFound {
+		t.Errorf("expected formats that were not drawn to be missing, got %v", err)
+	}
+
+	// A minimap published without its info is still described
+	if err := store.Put(ctx, minimaplib.MinimapName("main", minimaplib.MINIMAP_PNG), "image/png", []byte("second")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry, _ = serving.Get("main", minimaplib.MINIMAP_PNG, ctx)
+	got = entry.info
+
+	if got.ETag != minimaplib.MinimapETag([]byte("second")) || got.PagesScanned != 0 || got.GeneratedAt.IsZero() {
+		t.Errorf("expected info describing the stored image, got %+v", got)
+	}
+
+	// The drawing instance serves its own copy until it expires
+	if entry, _ := drawing.Get("main", minimaplib.MINIMAP_PNG, ctx); string(entry.data) != "first" {
+		t.Errorf("expected the copy in memory, got %q", entry.data)
+	}
+}
+
+// readCountingStore counts the objects read in full and can hold the reads of
+// one object until released.
+type readCountingStore struct {
+	minimaplib.MinimapStore
+
+	mu    sync.Mutex
+	reads map[string]int
+
+	hold     string
+	held     chan struct{}
+	released chan struct{}
+}
+
+func (s *readCountingStore) GetIfNoneMatch(ctx context.Context, name string, etag string) (*minimaplib.StoredObject, error) {
+	if name == s.hold {
+		s.held <- struct{}{}
+		<-s.released
+	}
+
+	obj, err := s.MinimapStore.GetIfNoneMatch(ctx, name, etag)
+
+	if err == nil {
+		s.mu.Lock()
+		s.reads[name]++
+		s.mu.Unlock()
+	}
+
+	return obj, err
+}
+
+func TestMinimapCacheRevalidates(t *testing.T) {
+	ctx := context.Background()
+	store := &readCountingStore{MinimapStore: &minimaplib.LocalStore{Dir: t.TempDir()}, reads: make(map[string]int)}
+	serving := NewMinimapCache(store, 0)
+	name := minimaplib.MinimapName("main", minimaplib.MINIMAP_PNG)
+
+	if err := NewMinimapCache(store, 0).Publish("main", map[minimaplib.MinimapFormat][]byte{minimaplib.MINIMAP_PNG: []byte("first")}, minimaplib.MinimapInfo{}, ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for range 3 {
+		if entry, err := serving.Get("main", minimaplib.MINIMAP_PNG, ctx); err != nil || string(entry.data) != "first" {
+			t.Fatalf("expected the published minimap, got %v", err)
+		}
+	}
+
+	if store.reads[name] != 1 || store.reads[minimaplib.MinimapInfoName("main")] != 1 {
+		t.Errorf("expected unchanged objects to be read once, got %v", store.reads)
+	}
+
+	if err := store.Put(ctx, name, "image/png", []byte("second")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if entry, _ := serving.Get("main", minimaplib.MINIMAP_PNG, ctx); string(entry.data) != "second" || entry.info.ETag != minimaplib.MinimapETag([]byte("second")) {
+		t.Errorf("expected the changed minimap, got %q", entry.data)
+	}
+}
+
+func TestMinimapCacheFetchesOutsideLock(t *testing.T) {
+	ctx := context.Background()
+	name := minimaplib.MinimapName("main", minimaplib.MINIMAP_PNG)
+	store := &readCountingStore{
+		MinimapStore: &minimaplib.LocalStore{Dir: t.TempDir()},
+		reads:        make(map[string]int),
+		held:         make(chan struct{}),
+		released:     make(chan struct{}),
+	}
+
+	variants := map[minimaplib.MinimapFormat][]byte{minimaplib.MINIMAP_PNG: []byte("png"), minimaplib.MINIMAP_BIN: []byte("bin")}
+
+	if _, err := minimaplib.Publish(store, "main", variants, minimaplib.MinimapInfo{}, ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	serving := NewMinimapCache(store, time.Minute)
+	store.hold = name
+
+	var wg sync.WaitGroup
+
+	for range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if entry, err := serving.Get("main", minimaplib.MINIMAP_PNG, ctx); err != nil || string(entry.data) != "png" {
+				t.Errorf("expected the published minimap, got %v", err)
+			}
+		}()
+	}
+
+	<-store.held
+
+	// Other minimaps are served while the first read is held
+	if entry, err := serving.Get("main", minimaplib.MINIMAP_BIN, ctx); err != nil || string(entry.data) != "bin" {
+		t.Errorf("expected the binary minimap, got %v", err)
+	}
+
+	close(store.released)
+	wg.Wait()
+
+	if store.reads[name] != 1 {
+		t.Errorf("expected concurrent gets to share a read, got %d", store.reads[name])
+	}
+}
+
+func TestDiffCacheEvictsOldest(t *testing.T) {
+	diffs := NewDiffCache(2)
+	snapshots := []*minimaplib.Snapshot{{Id: "a", ETag: `"a"`}, {Id: "b", ETag: `"b"`}, {Id: "c", ETag: `"c"`}}
+
+	diffs.Put("main", snapshots[0], snapshots[1], &cachedDiff{data: []byte("ab"), changed: 1})
+	diffs.Put("main", snapshots[1], snapshots[2], &cachedDiff{data: []byte("bc"), changed: 2})
+
+	if d := diffs.Get("main", snapshots[0], snapshots[1]); d == nil || string(d.data) != "ab" || d.changed != 1 {
+		t.Fatalf("expected the cached diff, got %+v", d)
+	}
+
+	if diffs.Get("event", snapshots[0], snapshots[1]) != nil || diffs.Get("main", snapshots[1], snapshots[0]) != nil {
+		t.Error("expected diffs of other canvases or the other way around to miss")
+	}
+
+	diffs.Put("main", snapshots[0], snapshots[2], &cachedDiff{data: []byte("ac"), changed: 3})
+
+	if diffs.Get("main", snapshots[0], snapshots[1]) != nil {
+		t.Error("expected the oldest diff to be evicted")
+	}
+
+	if diffs.Get("main", snapshots[1], snapshots[2]) == nil || diffs.Get("main", snapshots[0], snapshots[2]) == nil {
+		t.Error("expected the newer diffs to be kept")
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"maps"
+	"math"
+	"path"
+	"slices"
+	"strconv"
+
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
+)
+
+const MINIMAP_TILE_SIZE = 256
+
+// TileLayout describes the minimap tile pyramid of a canvas. At MaxZoom every
+// button is one pixel, laid out Side x Side per page like the UI does. Each
+// level above halves the resolution until level 0 fits a single tile.
+type TileLayout struct {
+	Side    int64 `json:"page_side"`
+	Width   int64 `json:"width"`
+	Height  int64 `json:"height"`
+	MaxZoom int   `json:"max_zoom"`
+	Size    int64 `json:"tile_size"`
+}
+
+func NewTileLayout(grid *GridGeometry) TileLayout {
+	side := int64(math.Sqrt(float64(grid.ButtonsPerPage)))
+
+	// Pages that are not square are drawn as a single averaged pixel
+	if side*side != grid.ButtonsPerPage {
+		side = 1
+	}
+
+	layout := TileLayout{
+		Side:   side,
+		Width:  grid.Cols * side,
+		Height: grid.Rows * side,
+		Size:   MINIMAP_TILE_SIZE,
+	}
+
+	for MINIMAP_TILE_SIZE<<layout.MaxZoom < max(layout.Width, layout.Height) {
+		layout.MaxZoom++
+	}
+
+	return layout
+}
+
+// LevelSize is the size in pixels of the whole map at zoom level z.
+func (l TileLayout) LevelSize(z int) (int64, int64) {
+	shift := l.MaxZoom - z
+	return ceilShift(l.Width, shift), ceilShift(l.Height, shift)
+}
+
+// TileCount is the number of tile columns and rows at zoom level z.
+func (l TileLayout) TileCount(z int) (int64, int64) {
+	w, h := l.LevelSize(z)
+	return ceilDiv(w, l.Size), ceilDiv(h, l.Size)
+}
+
+func (l TileLayout) Contains(z int, x int64, y int64) bool {
+	if z < 0 || z > l.MaxZoom || x < 0 || y < 0 {
+		return false
+	}
+
+	cols, rows := l.TileCount(z)
+	return x < cols && y < rows
+}
+
+func ceilShift(v int64, shift int) int64 {
+	return (v + (1 << shift) - 1) >> shift
+}
+
+func ceilDiv(a int64, b int64) int64 {
+	return (a + b - 1) / b
+}
+
+// MinimapTileName is the store object of a tile, tiles/{canvas}/{z}/{x}/{y}.png.
+func MinimapTileName(canvas string, z int, x int64, y int64) string {
+	return path.Join("tiles", canvas, strconv.Itoa(z), strconv.FormatInt(x, 10), strconv.FormatInt(y, 10)+".png")
+}
+
+// MinimapTileIndexName is the store object listing the tiles of a canvas that
+// hold presses. The store cannot list objects, so the index is kept next to
+// them.
+func MinimapTileIndexName(canvas string) string {
+	return path.Join("tiles", canvas, "index.json")
+}
+
+type tileIndex struct {
+	Canvas string   `json:"canvas"`
+	Tiles  []string `json:"tiles"`
+}
+
+// readTileIndex returns the drawn tiles of a canvas, and false when the canvas
+// has no index yet.
+func readTileIndex(store minimaplib.MinimapStore, canvas string, ctx context.Context) (map[string]bool, bool, error) {
+	drawn := make(map[string]bool)
+	obj, err := store.Get(ctx, MinimapTileIndexName(canvas))
+
+	if err == minimaplib.ErrNotFound {
+		return drawn, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	idx := tileIndex{}
+
+	if err := json.Unmarshal(obj.Data, &idx); err != nil {
+		return nil, false, err
+	}
+
+	for _, name := range idx.Tiles {
+		drawn[name] = true
+	}
+
+	return drawn, true, nil
+}
+
+func writeTileIndex(store minimaplib.MinimapStore, canvas string, drawn map[string]bool, ctx context.Context) error {
+	idxJson, err := json.Marshal(tileIndex{Canvas: canvas, Tiles: slices.Sorted(maps.Keys(drawn))})
+
+	if err != nil {
+		return err
+	}
+
+	return store.Put(ctx, MinimapTileIndexName(canvas), "application/json", idxJson)
+}
+
+// tilePyramid renders the pyramid one band of tile rows at a time, so only a
+// band per level is ever in memory. Finished bands are cut into tiles and
+// downsampled into the band of the level above.
+//
+// drawn tracks the tiles in the store that hold presses, so only tiles that
+// were emptied are deleted. Without an index every empty tile is deleted once.
+type tilePyramid struct {
+	layout  TileLayout
+	store   minimaplib.MinimapStore
+	ctx     context.Context
+	canvas  string
+	bands   []*image.RGBA
+	encoder png.Encoder
+	written int
+	drawn   map[string]bool
+	indexed bool
+	seen    map[string]bool
+}
+
+func newTilePyramid(layout TileLayout, store minimaplib.MinimapStore, canvas string, ctx context.Context) (*tilePyramid, error) {
+	drawn, indexed, err := readTileIndex(store, canvas, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &tilePyramid{
+		layout:  layout,
+		store:   store,
+		ctx:     ctx,
+		canvas:  canvas,
+		bands:   make([]*image.RGBA, layout.MaxZoom+1),
+		encoder: png.Encoder{CompressionLevel: png.DefaultCompression},
+		drawn:   drawn,
+		indexed: indexed,
+		seen:    make(map[string]bool),
+	}
+
+	// Bands are padded to whole tiles so edge tiles are full size too
+	for z := range p.bands {
+		cols, _ := layout.TileCount(z)
+		p.bands[z] = image.NewRGBA(image.Rect(0, 0, int(cols*layout.Size), int(layout.Size)))
+	}
+
+	return p, nil
+}
+
+// RenderMinimapTiles renders every tile of a canvas from the raw button state.
+func RenderMinimapTiles(db MinimapDb, store minimaplib.MinimapStore, canvas string, grid *GridGeometry, mode minimaplib.MinimapColorMode, ctx context.Context) (int, error) {
+	p, err := newTilePyramid(NewTileLayout(grid), store, canvas, ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = p.render(db, grid, mode)
+
+	if err == nil {
+		err = p.deleteUnseen()
+	}
+
+	// The index follows the store, a failed run included
+	if errIndex := writeTileIndex(store, canvas, p.drawn, ctx); err == nil {
+		err = errIndex
+	}
+
+	return p.written, err
+}
+
+func (p *tilePyramid) render(db MinimapDb, grid *GridGeometry, mode minimaplib.MinimapColorMode) error {
+	layout := p.layout
+	canvas, ctx := p.canvas, p.ctx
+	_, bandCount := layout.TileCount(layout.MaxZoom)
+
+	for b := int64(0); b < bandCount; b++ {
+		band := p.bands[layout.MaxZoom]
+		clear(band.Pix)
+
+		// Button rows covered by this band, and the pages holding them
+		top := b * layout.Size
+		pageFrom := top/layout.Side + 1
+		pageTo := (top+layout.Size-1)/layout.Side + 1
+
+		if pageTo > grid.Rows {
+			pageTo = grid.Rows
+		}
+
+		err := db.ReadPageRows(canvas, pageFrom, pageTo, ctx, func(x int64, y int64, buttons []byte) {
+			drawPage(band, layout, mode, x, y, top, buttons)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if err := p.finishBand(layout.MaxZoom, b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteUnseen deletes the drawn tiles that are no longer part of the
+// pyramid, after the layout changed.
+func (p *tilePyramid) deleteUnseen() error {
+	for name := range p.drawn {
+		if p.seen[name] {
+			continue
+		}
+
+		if err := p.store.Delete(p.ctx, name); err != nil {
+			return err
+		}
+
+		delete(p.drawn, name)
+	}
+
+	return nil
+}
+
+// drawPage draws the buttons of page (x, y) into a band starting at button
+// row top. Unpressed buttons stay transparent, and pages that are not square
+// are drawn as one pixel colored by mode.
+func drawPage(band *image.RGBA, layout TileLayout, mode minimaplib.MinimapColorMode, x int64, y int64, top int64, buttons []byte) {
+	if layout.Side == 1 {
+		if rgb, ok := minimaplib.PageColor(mode, buttons); ok {
+			setOpaque(band, x-1, y-1-top, rgb)
+		}
+
+		return
+	}
+
+	for i := int64(0); i < int64(len(buttons))/3; i++ {
+		rgb := buttons[i*3 : i*3+3]
+
+		if !minimaplib.IsPressed(rgb) {
+			continue
+		}
+
+		bx := (x-1)*layout.Side + i%layout.Side
+		by := (y-1)*layout.Side + i/layout.Side - top
+
+		if by >= 0 && by < layout.Size {
+			setOpaque(band, bx, by, rgb)
+		}
+	}
+}
+
+func setOpaque(img *image.RGBA, x int64, y int64, rgb []byte) {
+	o := img.PixOffset(int(x), int(y))
+	img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = rgb[0], rgb[1], rgb[2], 0xff
+}
+
+// finishBand writes the tiles of band b at level z and folds it into the
+// level above, which is finished in turn once both of its halves are in.
+func (p *tilePyramid) finishBand(z int, b int64) error {
+	band := p.bands[z]
+
+	if err := p.writeTiles(z, b, band); err != nil {
+		return err
+	}
+
+	if z == 0 {
+		return nil
+	}
+
+	parent := p.bands[z-1]
+	downsampleInto(parent, band, int(b%2)*int(p.layout.Size/2))
+
+	_, bandCount := p.layout.TileCount(z)
+
+	if b%2 == 1 || b == bandCount-1 {
+		if err := p.finishBand(z-1, b/2); err != nil {
+			return err
+		}
+
+		clear(parent.Pix)
+	}
+
+	return nil
+}
+
+// downsampleInto halves src into dst starting at row offset. Each pixel is
+// the mean of the opaque pixels under it, so sparse presses stay visible.
+func downsampleInto(dst *image.RGBA, src *image.RGBA, offset int) {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+
+	for y := 0; y < sh/2; y++ {
+		for x := 0; x < dst.Rect.Dx(); x++ {
+			var r, g, b, n int
+
+			for dy := 0; dy < 2; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					sx := 2*x + dx
+
+					if sx >= sw {
+						continue
+					}
+
+					o := src.PixOffset(sx, 2*y+dy)
+
+					if src.Pix[o+3] == 0 {
+						continue
+					}
+
+					r, g, b, n = r+int(src.Pix[o]), g+int(src.Pix[o+1]), b+int(src.Pix[o+2]), n+1
+				}
+			}
+
+			if n > 0 {
+				setOpaque(dst, int64(x), int64(offset+y), []byte{byte(r / n), byte(g / n), byte(b / n)})
+			}
+		}
+	}
+}
+
+func (p *tilePyramid) writeTiles(z int, row int64, band *image.RGBA) error {
+	cols, _ := p.layout.TileCount(z)
+	size := int(p.layout.Size)
+
+	for col := int64(0); col < cols; col++ {
+		tile := band.SubImage(image.Rect(int(col)*size, 0, int(col+1)*size, size)).(*image.RGBA)
+		name := MinimapTileName(p.canvas, z, col, row)
+		p.seen[name] = true
+
+		// Empty tiles are served from memory, and a new season can empty a tile
+		if isTransparent(tile) {
+			if !p.drawn[name] && p.indexed {
+				continue
+			}
+
+			if err := p.store.Delete(p.ctx, name); err != nil {
+				return err
+			}
+
+			delete(p.drawn, name)
+			continue
+		}
+
+		if err := publishPng(p.store, name, tile, &p.encoder, p.ctx); err != nil {
+			return err
+		}
+
+		p.drawn[name] = true
+		p.written++
+	}
+
+	return nil
+}
+
+func isTransparent(img *image.RGBA) bool {
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, y) : img.PixOffset(img.Rect.Max.X-1, y)+4]
+
+		for i := 3; i < len(row); i += 4 {
+			if row[i] != 0 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
+)
+
+func TestTileLayout(t *testing.T) {
+	layout := NewTileLayout(&GridGeometry{Cols: 100, Rows: 20, ButtonsPerPage: 16})
+
+	if layout.Side != 4 || layout.Width != 400 || layout.Height != 80 {
+		t.Fatalf("unexpected layout %+v", layout)
+	}
+
+	if layout.MaxZoom != 1 {
+		t.Errorf("expected max zoom 1, got %d", layout.MaxZoom)
+	}
+
+	if cols, rows := layout.TileCount(1); cols != 2 || rows != 1 {
+		t.Errorf("expected 2x1 tiles at zoom 1, got %dx%d", cols, rows)
+	}
+
+	if cols, rows := layout.TileCount(0); cols != 1 || rows != 1 {
+		t.Errorf("expected 1x1 tiles at zoom 0, got %dx%d", cols, rows)
+	}
+
+	if !layout.Contains(1, 1, 0) || layout.Contains(1, 2, 0) || layout.Contains(2, 0, 0) {
+		t.Error("unexpected tile bounds")
+	}
+
+	if NewTileLayout(&GridGeometry{Cols: 3, Rows: 3, ButtonsPerPage: 12}).Side != 1 {
+		t.Error("expected pages that are not square to be drawn as one pixel")
+	}
+}
+
+func TestRenderMinimapTiles(t *testing.T) {
+	store := &minimaplib.LocalStore{Dir: t.TempDir()}
+
+	grid := &GridGeometry{Cols: 100, Rows: 100, ButtonsPerPage: 16}
+	buttons := make([]byte, grid.PageBytes())
+
+	// Second button of the second row of the page
+	copy(buttons[5*3:], []byte{0xff, 0x00, 0x00})
+
+	db := &minimaplib.MemoryPageDb{Items: []*minimaplib.MinimapItem{{X: 70, Y: 1, Buttons: buttons}}}
+
+	written, err := RenderMinimapTiles(db, store, "main", grid, minimaplib.MINIMAP_COLOR_MEAN, context.Background())
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// One tile at each of zoom 0 and 1, the rest of the map is empty
+	if written != 2 {
+		t.Errorf("expected 2 tiles, got %d", written)
+	}
+
+	// Page 70 starts at button column 276, which falls into the second tile
+	tile := readTile(t, store, MinimapTileName("main", 1, 1, 0))
+
+	if r, _, _, a := tile.At(276+1-256, 1).RGBA(); r != 0xffff || a != 0xffff {
+		t.Errorf("expected an opaque red button, got r=%x a=%x", r, a)
+	}
+
+	if _, _, _, a := tile.At(276-256, 1).RGBA(); a != 0 {
+		t.Errorf("expected an unpressed button to be transparent, got a=%x", a)
+	}
+
+	// Downsampled, the pressed button keeps its color
+	if r, _, _, a := readTile(t, store, MinimapTileName("main", 0, 0, 0)).At(277/2, 0).RGBA(); r != 0xffff || a != 0xffff {
+		t.Errorf("expected the button to survive downsampling, got r=%x a=%x", r, a)
+	}
+
+	if _, err := store.Get(context.Background(), MinimapTileName("main", 1, 0, 0)); err != minimaplib.ErrNotFound {
+		t.Errorf("expected the empty tile not to be written, got %v", err)
+	}
+}
+
+// deleteCountingStore records the objects deleted from the store below.
+type deleteCountingStore struct {
+	minimaplib.MinimapStore
+	deleted []string
+}
+
+func (s *deleteCountingStore) Delete(ctx context.Context, name string) error {
+	s.deleted = append(s.deleted, name)
+	return s.MinimapStore.Delete(ctx, name)
+}
+
+func TestRenderMinimapTilesDeletesOnlyEmptiedTiles(t *testing.T) {
+	store := &deleteCountingStore{MinimapStore: &minimaplib.LocalStore{Dir: t.TempDir()}}
+	ctx := context.Background()
+
+	grid := &GridGeometry{Cols: 100, Rows: 100, ButtonsPerPage: 16}
+	buttons := make([]byte, grid.PageBytes())
+	copy(buttons, []byte{0xff, 0x00, 0x00})
+
+	db := &minimaplib.MemoryPageDb{Items: []*minimaplib.MinimapItem{{X: 70, Y: 1, Buttons: buttons}}}
+	render := func() {
+		store.deleted = nil
+
+		if _, err := RenderMinimapTiles(db, store, "main", grid, minimaplib.MINIMAP_COLOR_MEAN, ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Without an index every empty tile is deleted once
+	render()
+
+	if len(store.deleted) != 3 {
+		t.Errorf("expected the 3 empty tiles to be deleted, got %v", store.deleted)
+	}
+
+	render()
+
+	if len(store.deleted) != 0 {
+		t.Errorf("expected no deletes while nothing was emptied, got %v", store.deleted)
+	}
+
+	// A new season empties the canvas
+	db.Items = nil
+	render()
+
+	if len(store.deleted) != 2 {
+		t.Errorf("expected the 2 drawn tiles to be deleted, got %v", store.deleted)
+	}
+
+	if _, err := store.Get(ctx, MinimapTileName("main", 1, 1, 0)); err != minimaplib.ErrNotFound {
+		t.Errorf("expected the emptied tile to be deleted, got %v", err)
+	}
+
+	// Tiles outside of a new layout are deleted too
+	db.Items = []*minimaplib.MinimapItem{{X: 70, Y: 1, Buttons: buttons}}
+	render()
+
+	grid = &GridGeometry{Cols: 10, Rows: 10, ButtonsPerPage: 16}
+	db.Items = nil
+	render()
+
+	if _, err := store.Get(ctx, MinimapTileName("main", 1, 1, 0)); err != minimaplib.ErrNotFound {
+		t.Errorf("expected a tile outside of the layout to be deleted, got %v", err)
+	}
+}
+
+func readTile(t *testing.T, store minimaplib.MinimapStore, name string) image.Image {
+	obj, err := store.Get(context.Background(), name)
+
+	if err != nil {
+		t.Fatalf("could not read tile: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(obj.Data))
+
+	if err != nil {
+		t.Fatalf("could not decode tile: %v", err)
+	}
+
+	return img
+}
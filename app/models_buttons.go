@@ -1,76 +1,65 @@
 package main
 
 import (
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
-	"strconv"
 )
 
+// Default grid geometry, used when the database does not record one.
 const BUTTON_ROWS int64 = 2500
 const BUTTON_COLS int64 = 4096
 const BUTTONS_PER_PAGE int64 = 100
 const BUTTONS_PER_ROW int64 = BUTTON_COLS * BUTTONS_PER_PAGE
+const PRESS_TIME_BYTES int64 = 4
+const PAGE_BINARY_HEADER_BYTES int64 = 12
 
 type ButtonState struct {
-	id int64
-	r  byte
-	g  byte
-	b  byte
+	id        int64
+	r         byte
+	g         byte
+	b         byte
+	pressedAt int64
+	// page version produced by the press of this button
+	pressedVersion int64
 }
 
 type ButtonStateDto struct {
-	ID  int64  `json:"id"`
-	Hex string `json:"hex,omitempty"`
+	ID        int64  `json:"id"`
+	Hex       string `json:"hex,omitempty"`
+	PressedAt int64  `json:"pressed_at,omitempty"`
+}
+
+type GlobalButtonDto struct {
+	X int64 `json:"x"`
+	Y int64 `json:"y"`
+	ButtonStateDto
 }
 
 type GridPageDto struct {
 	X       int64            `json:"x"`
 	Y       int64            `json:"y"`
+	Version int64            `json:"version"`
 	Buttons []ButtonStateDto `json:"buttons"`
 	Next    string           `json:"next"`
 }
 
+// HexToBytes parses a client supplied color into RGB bytes. Despite the name
+// it accepts every format understood by ParseColor.
 func HexToBytes(hex string) ([]byte, error) {
-	if len(hex) < 6 {
-		return nil, errors.New("hex string is insufficient length")
-	}
-
-	if hex[0] == '#' {
-		hex = hex[1:7]
-	}
-
-	r, errR := strconv.ParseInt(hex[0:2], 16, 64)
-	g, errG := strconv.ParseInt(hex[2:4], 16, 64)
-	b, errB := strconv.ParseInt(hex[4:6], 16, 64)
-
-	if errR != nil || errG != nil || errB != nil {
-		return nil, errors.New("could not parse hex code")
-	}
-
-	bits := []byte{byte(r), byte(g), byte(b)}
-	return bits, nil
+	return ParseColor(hex)
 }
 
 func (s *ButtonState) fromHex(hex string) error {
-	if len(hex) < 6 {
-		return errors.New("hex string is insufficient length")
-	}
-
-	if hex[0] == '#' {
-		hex = hex[1:7]
-	}
+	rgb, err := ParseColor(hex)
 
-	r, errR := strconv.ParseInt(hex[0:2], 16, 64)
-	g, errG := strconv.ParseInt(hex[2:4], 16, 64)
-	b, errB := strconv.ParseInt(hex[4:6], 16, 64)
-
-	if errR != nil || errG != nil || errB != nil {
-		return errors.New("could not parse hex code")
+	if err != nil {
+		return err
 	}
 
-	s.r = byte(r)
-	s.g = byte(g)
-	s.b = byte(b)
+	s.r = rgb[0]
+	s.g = rgb[1]
+	s.b = rgb[2]
 	return nil
 }
 
@@ -90,6 +79,7 @@ type GridPage struct {
 	X       int64
 	Y       int64
 	Buttons []ButtonState
+	grid    *GridGeometry
 }
 
 func HexCodesAreEquivalent(hex1 string, hex2 string) bool {
@@ -105,19 +95,8 @@ func HexCodesAreEquivalent(hex1 string, hex2 string) bool {
 		bytes1[2] == bytes2[2]
 }
 
-func ButtonLocationToIndex(x int64, y int64, id int64) (int64, error) {
-	offset := ((y - 1) * BUTTON_COLS * BUTTONS_PER_PAGE) + ((x - 1) * BUTTONS_PER_PAGE)
-	ix := id - offset
-
-	if ix < 0 || ix > BUTTONS_PER_PAGE-1 {
-		return -1, errors.New("asked for button on incorrect page")
-	}
-
-	return ix, nil
-}
-
 func (s *GridPage) GetButtonById(id int64) *ButtonState {
-	ix, err := ButtonLocationToIndex(s.X, s.Y, id)
+	ix, err := s.grid.ButtonLocationToIndex(s.X, s.Y, id)
 
 	if err != nil {
 		panic(err)
@@ -127,7 +106,7 @@ func (s *GridPage) GetButtonById(id int64) *ButtonState {
 }
 
 func (s *GridPage) EncodeStates() []byte {
-	data := make([]byte, 3*BUTTONS_PER_PAGE)
+	data := make([]byte, 3*len(s.Buttons))
 
 	for i, dx := 0, 0; i < len(s.Buttons); i, dx = i+1, dx+3 {
 		data[dx] = s.Buttons[i].r
@@ -138,25 +117,76 @@ func (s *GridPage) EncodeStates() []byte {
 	return data
 }
 
-func CreateGridPage(x int64, y int64, data []byte) *GridPage {
-	buttonState := make([]ButtonState, BUTTONS_PER_PAGE)
+// ApplyPressTimes decodes the per-button press timestamps stored alongside a
+// page. Each button has a 4 byte big-endian unix timestamp, zero if unpressed.
+// Timestamps are unsigned, so they run until 2106.
+func (s *GridPage) ApplyPressTimes(data []byte) error {
+	times, err := decodePerButtonUint32(data, len(s.Buttons))
+
+	if err != nil {
+		return err
+	}
+
+	for i := range s.Buttons {
+		s.Buttons[i].pressedAt = times[i]
+	}
+
+	return nil
+}
+
+// ApplyPressVersions decodes the per-button page versions stored alongside a
+// page. Each button has the 4 byte big-endian page version of its press.
+func (s *GridPage) ApplyPressVersions(data []byte) error {
+	versions, err := decodePerButtonUint32(data, len(s.Buttons))
+
+	if err != nil {
+		return err
+	}
+
+	for i := range s.Buttons {
+		s.Buttons[i].pressedVersion = versions[i]
+	}
 
-	rowIx := x - 1
-	colIx := y - 1
+	return nil
+}
 
-	for i := range buttonState {
-		id := colIx*BUTTONS_PER_ROW + (rowIx * BUTTONS_PER_PAGE) + int64(i)
-		buttonState[i] = ButtonState{
-			id: id,
-			r:  data[i*3],
-			g:  data[(i*3)+1],
-			b:  data[(i*3)+2],
+// ChangedSince returns the buttons pressed after version, up to and including
+// the page version the caller read alongside the button state.
+func (s *GridPage) ChangedSince(version int64, current int64) []ButtonState {
+	changed := make([]ButtonState, 0)
+
+	for _, b := range s.Buttons {
+		if b.pressedVersion > version && b.pressedVersion <= current {
+			changed = append(changed, b)
 		}
 	}
 
-	return &GridPage{
-		X:       x,
-		Y:       y,
-		Buttons: buttonState,
+	return changed
+}
+
+func decodePerButtonUint32(data []byte, count int) ([]int64, error) {
+	if int64(len(data)) != PRESS_TIME_BYTES*int64(count) {
+		return nil, errors.New("per-button data does not match page size")
+	}
+
+	vals := make([]int64, count)
+
+	for i := range vals {
+		vals[i] = int64(binary.BigEndian.Uint32(data[int64(i)*PRESS_TIME_BYTES:]))
 	}
+
+	return vals, nil
+}
+
+// EncodePageBinary builds the compact wire format for a page: a 12 byte header
+// of big-endian uint32 x, y and version followed by the raw RGB bytes. Button
+// ids are implied by the position of each button, as in CreateGridPage.
+func EncodePageBinary(x int64, y int64, version int64, data []byte) []byte {
+	buf := make([]byte, PAGE_BINARY_HEADER_BYTES, PAGE_BINARY_HEADER_BYTES+int64(len(data)))
+
+	binary.BigEndian.PutUint32(buf[0:], uint32(x))
+	binary.BigEndian.PutUint32(buf[4:], uint32(y))
+	binary.BigEndian.PutUint32(buf[8:], uint32(version))
+
+	return append(buf, data...)
 }
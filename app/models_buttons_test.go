@@ -25,6 +25,14 @@ var convertTests = map[string]struct {
 	},
 }
 
+// DefaultGridGeometry is the geometry seeded by makedb.
+var DefaultGridGeometry = GridGeometry{
+	Cols:           BUTTON_COLS,
+	Rows:           BUTTON_ROWS,
+	IdCols:         BUTTON_COLS,
+	ButtonsPerPage: BUTTONS_PER_PAGE,
+}
+
 func TestButtonStateFromHex(t *testing.T) {
 
 	for name, test := range convertTests {
@@ -120,7 +128,7 @@ func TestButtonLocationToIndex(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			ix, err := ButtonLocationToIndex(test.x, test.y, test.id)
+			ix, err := DefaultGridGeometry.ButtonLocationToIndex(test.x, test.y, test.id)
 
 			if test.expectError {
 				if err == nil {
@@ -197,9 +205,21 @@ func TestHexCodesAreEquivalent(t *testing.T) {
 			expected: false,
 		},
 		{
-			name:     "too short hex",
+			name:     "shorthand hex",
 			hex1:     "fff",
 			hex2:     "ffffff",
+			expected: true,
+		},
+		{
+			name:     "named color",
+			hex1:     "red",
+			hex2:     "#ff0000",
+			expected: true,
+		},
+		{
+			name:     "too short hex",
+			hex1:     "ff",
+			hex2:     "ffffff",
 			expected: false,
 		},
 	}
@@ -247,8 +267,14 @@ func TestHexToBytes(t *testing.T) {
 			expectErr: false,
 		},
 		{
-			name:      "too short hex",
+			name:      "shorthand hex",
 			hex:       "fff",
+			expected:  []byte{255, 255, 255},
+			expectErr: false,
+		},
+		{
+			name:      "too short hex",
+			hex:       "ff",
 			expected:  nil,
 			expectErr: true,
 		},
@@ -265,10 +291,10 @@ func TestHexToBytes(t *testing.T) {
 			expectErr: true,
 		},
 		{
-			name:      "long hex string should work",
+			name:      "trailing characters are rejected",
 			hex:       "#ffaabb00",
-			expected:  []byte{255, 170, 187},
-			expectErr: false,
+			expected:  nil,
+			expectErr: true,
 		},
 		{
 			name:      "2025-09-13 bug",
@@ -364,7 +390,7 @@ func TestCreateGridPage(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result := CreateGridPage(test.x, test.y, test.data)
+			result := DefaultGridGeometry.CreateGridPage(test.x, test.y, test.data)
 
 			if result.X != test.expected.X {
 				t.Errorf("expected X=%d, got X=%d", test.expected.X, result.X)
@@ -412,7 +438,7 @@ func TestGridPageGetButtonById(t *testing.T) {
 	data[10] = 255
 	data[11] = 0
 
-	page := CreateGridPage(1, 1, data)
+	page := DefaultGridGeometry.CreateGridPage(1, 1, data)
 
 	tests := []struct {
 		name        string
@@ -594,3 +620,146 @@ func TestButtonStateIsEmpty(t *testing.T) {
 		})
 	}
 }
+
+func TestGridPageApplyPressTimes(t *testing.T) {
+	page := DefaultGridGeometry.CreateGridPage(1, 1, make([]byte, 300))
+
+	times := make([]byte, 400)
+	// Button 0 pressed at 1700000000 (0x6553F100)
+	times[0], times[1], times[2], times[3] = 0x65, 0x53, 0xF1, 0x00
+	// Button 99 pressed at 1
+	times[399] = 1
+
+	if err := page.ApplyPressTimes(times); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if page.Buttons[0].pressedAt != 1700000000 {
+		t.Errorf("expected button 0 pressedAt=1700000000, got %d", page.Buttons[0].pressedAt)
+	}
+
+	if page.Buttons[1].pressedAt != 0 {
+		t.Errorf("expected button 1 pressedAt=0, got %d", page.Buttons[1].pressedAt)
+	}
+
+	if page.Buttons[99].pressedAt != 1 {
+		t.Errorf("expected button 99 pressedAt=1, got %d", page.Buttons[99].pressedAt)
+	}
+
+	if err := page.ApplyPressTimes(make([]byte, 12)); err == nil {
+		t.Errorf("expected error for short press time data")
+	}
+}
+
+func TestGridPageChangedSince(t *testing.T) {
+	page := DefaultGridGeometry.CreateGridPage(1, 1, make([]byte, 300))
+
+	versions := make([]byte, 400)
+	// Button 2 pressed at version 1, button 5 at version 2, button 7 at version 3
+	versions[(2*4)+3] = 1
+	versions[(5*4)+3] = 2
+	versions[(7*4)+3] = 3
+
+	if err := page.ApplyPressVersions(versions); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		since    int64
+		current  int64
+		expected []int64
+	}{
+		{name: "everything", since: 0, current: 3, expected: []int64{2, 5, 7}},
+		{name: "after first press", since: 1, current: 3, expected: []int64{5, 7}},
+		{name: "up to date", since: 3, current: 3, expected: []int64{}},
+		{name: "ignores presses newer than current", since: 0, current: 2, expected: []int64{2, 5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changed := page.ChangedSince(test.since, test.current)
+
+			if len(changed) != len(test.expected) {
+				t.Fatalf("expected %d changed buttons, got %d", len(test.expected), len(changed))
+			}
+
+			for i := range changed {
+				if changed[i].id != test.expected[i] {
+					t.Errorf("changed[%d]: expected id=%d, got id=%d", i, test.expected[i], changed[i].id)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodePageBinary(t *testing.T) {
+	data := make([]byte, 300)
+	data[0] = 255
+	data[299] = 7
+
+	result := EncodePageBinary(2, 3, 258, data)
+
+	if len(result) != 312 {
+		t.Fatalf("expected length 312, got %d", len(result))
+	}
+
+	header := []byte{0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 1, 2}
+
+	for i := range header {
+		if result[i] != header[i] {
+			t.Errorf("header byte[%d]: expected %d, got %d", i, header[i], result[i])
+		}
+	}
+
+	if result[12] != 255 || result[311] != 7 {
+		t.Errorf("button bytes were not copied after the header")
+	}
+}
+
+func TestButtonIdToLocation(t *testing.T) {
+	tests := []struct {
+		name        string
+		id          int64
+		x           int64
+		y           int64
+		ix          int64
+		expectError bool
+	}{
+		{name: "first button", id: 0, x: 1, y: 1, ix: 0},
+		{name: "last button of first page", id: 99, x: 1, y: 1, ix: 99},
+		{name: "first button of (2,1)", id: 100, x: 2, y: 1, ix: 0},
+		{name: "first button of (1,2)", id: BUTTONS_PER_ROW, x: 1, y: 2, ix: 0},
+		{name: "middle of (2,3)", id: 2*BUTTONS_PER_ROW + 142, x: 2, y: 3, ix: 42},
+		{name: "last button", id: BUTTON_ROWS*BUTTONS_PER_ROW - 1, x: BUTTON_COLS, y: BUTTON_ROWS, ix: 99},
+		{name: "negative id", id: -1, expectError: true},
+		{name: "past the end", id: BUTTON_ROWS * BUTTONS_PER_ROW, expectError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			x, y, ix, err := DefaultGridGeometry.ButtonIdToLocation(test.id)
+
+			if test.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if x != test.x || y != test.y || ix != test.ix {
+				t.Errorf("expected (%d, %d, %d) but got (%d, %d, %d)", test.x, test.y, test.ix, x, y, ix)
+			}
+
+			// Round trip back through the forward mapping
+			fwd, err := DefaultGridGeometry.ButtonLocationToIndex(x, y, test.id)
+			if err != nil || fwd != ix {
+				t.Errorf("ButtonLocationToIndex(%d, %d, %d) = %d, %v; expected %d", x, y, test.id, fwd, err, ix)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/cmcquillan/one-billion-buttons/dblib"
+	"github.com/gin-gonic/gin"
+)
+
+// SchemaState tracks whether the database has every migration embedded in
+// the app. The app never serves against a schema that is behind unless
+// REQUIRE_CURRENT_SCHEMA is off, in which case it only fails readiness.
+type SchemaState struct {
+	current atomic.Bool
+}
+
+func (s *SchemaState) Current() bool {
+	return s.current.Load()
+}
+
+// Refresh compares the applied migrations with the embedded ones. An applied
+// migration whose script has changed is an error.
+func (s *SchemaState) Refresh(db dblib.DbString) error {
+	var pending []dblib.Migration
+
+	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
+		var err error
+		pending, err = dblib.PendingMigrations(dbc)
+		return err
+	})
+
+	if err != nil {
+		s.current.Store(false)
+		return err
+	}
+
+	if len(pending) > 0 && s.Current() {
+		log.Printf("database schema is behind, %d migrations pending", len(pending))
+	}
+
+	s.current.Store(len(pending) == 0)
+	return nil
+}
+
+// MigrateSchema applies pending migrations under the migration lock so only
+// one replica migrates. Replicas that miss the lock wait for the one that
+// holds it to finish.
+func MigrateSchema(db dblib.DbString, locker dblib.Lock, schema *SchemaState, cfg *Config) error {
+	err := dblib.OpenConnAndExec(db, dblib.EnsureMigrationTables)
+
+	if err != nil {
+		return err
+	}
+
+	lockVal, err := locker.AcquireLock(dblib.MIGRATE_LOCK_TYPE, cfg.MigrateLockTimeout)
+
+	if err == dblib.ErrLockNotAcquired {
+		log.Printf("%s lock already acquired, waiting for migrations", dblib.MIGRATE_LOCK_TYPE)
+		return waitForSchema(db, schema, cfg)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer locker.ReleaseLock(lockVal)
+
+	log.Printf("lock %s acquired for %s", lockVal.Value, lockVal.Type)
+
+	return dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
+		return dblib.MigrateUp(dbc, false)
+	})
+}
+
+func waitForSchema(db dblib.DbString, schema *SchemaState, cfg *Config) error {
+	deadline := time.Now().Add(cfg.MigrateLockTimeout)
+
+	for time.Now().Before(deadline) {
+		if err := schema.Refresh(db); err != nil {
+			return err
+		}
+
+		if schema.Current() {
+			return nil
+		}
+
+		time.Sleep(cfg.SchemaCheckInterval)
+	}
+
+	return dblib.ErrSchemaBehind
+}
+
+func BackgroundCheckSchema(db dblib.DbString, schema *SchemaState, ctx context.Context, cfg *Config) {
+	log.Printf("Background schema check worker started")
+	ticker := time.NewTicker(cfg.SchemaCheckInterval)
+	done := false
+	for !done {
+		select {
+		case <-ctx.Done():
+			log.Printf("Background schema check worker stopping")
+			done = true
+		case <-ticker.C:
+			if err := schema.Refresh(db); err != nil {
+				log.Printf("could not check database schema: %v", err)
+			}
+		}
+	}
+
+	log.Printf("Background schema check worker stopped")
+}
+
+func (s *SchemaState) HandleReady(c *gin.Context) {
+	if !s.Current() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Database schema is behind",
+		})
+
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -10,12 +10,14 @@
  * @typedef ButtonState
  * @property {number} id
  * @property {string?} hex
+ * @property {number?} pressed_at
  */
 
 /**
  * @interface GridState 
  * @implements {GridPoint}
  * @property {ButtonState[]} buttons
+ * @property {number} version
  * @property {string?} next
  */
 
@@ -29,6 +31,14 @@
  * @property {number} order
  */
 
+/**
+ * @typedef GridGeometry
+ * @property {number} cols
+ * @property {number} rows
+ * @property {number} id_cols
+ * @property {number} buttons_per_page
+ */
+
 window.CSS.registerProperty({
     name: '--cursor-url',
     inherits: true,
@@ -36,13 +46,19 @@ window.CSS.registerProperty({
 });
 
 class Api {
-    constructor() {
+    /**
+     * 
+     * @param {string?} canvas Named canvas to use, the main canvas when empty
+     */
+    constructor(canvas) {
         this.gets = {};
         this.posts = {};
+        this.base = canvas ? `/api/c/${encodeURIComponent(canvas)}` : '/api';
+        this.minimapUrl = canvas ? `/c/${encodeURIComponent(canvas)}/minimap.png` : '/minimap.png';
     }
 
     async _getButtonsAtCoordinates(x, y) {
-        const resp = await fetch(`/api/${x}/${y}`);
+        const resp = await fetch(`${this.base}/${x}/${y}?times=true`);
         if (resp.status === 200) {
             const state = resp.json();
             return state;
@@ -54,7 +70,7 @@ class Api {
     async _pressButton(x, y, id, hex) {
         id = parseInt(id);
 
-        const resp = await fetch(`/api/${x}/${y}`, {
+        const resp = await fetch(`${this.base}/${x}/${y}?times=true`, {
             method: 'POST',
             body: JSON.stringify({ id, hex }),
         });
@@ -70,6 +86,23 @@ class Api {
         });
     };
 
+    /**
+     * Only the buttons pressed after version, or null when nothing changed.
+     * 
+     * @param {number} x 
+     * @param {number} y 
+     * @param {number} version 
+     * @returns {Promise<GridState|null>}
+     */
+    async getButtonsSince(x, y, version) {
+        const resp = await fetch(`${this.base}/${x}/${y}?times=true&since=${version}`);
+        if (resp.status === 200) {
+            return resp.json();
+        }
+
+        return null;
+    };
+
     getButtons(x, y) {
         const key = `${x}_${y}`;
 
@@ -102,12 +135,26 @@ class Api {
         return this._pressButton(x, y, id, hex);
     };
 
+    /**
+     * 
+     * @returns {Promise<GridGeometry|null>}
+     */
+    getGridGeometry() {
+        return fetch(`${this.base}/grid`)
+            .then(resp => {
+                if (resp.status === 200) {
+                    return resp.json();
+                }
+                return null;
+            });
+    }
+
     /**
      * 
      * @returns {Promise<ButtonStat[]>}
      */
     getStats() {
-        return fetch('/api/stats')
+        return fetch(`${this.base}/stats`)
             .then(resp => {
                 if (resp.status === 200) {
                     return resp.json();
@@ -327,8 +374,9 @@ class LocalState {
         this.buttonStates = {};
         this.interval = null;
         this.eventInterval = null;
+        this.pollInterval = null;
         this.observer = null;
-        this.api = new Api();
+        this.api = new Api(new URLSearchParams(window.location.search).get('canvas'));
         this.panelTracker = null;
         this.debug = false;
     }
@@ -356,11 +404,16 @@ class LocalState {
         const key = `${buttonState.x}_${buttonState.y}`;
         let modified = false;
 
-        if (this.buttonStates[key]) {
+        const stored = this.buttonStates[key];
 
-            for (let i = 0; i < buttonState.buttons.length; i++) {
-                if (buttonState.buttons[i].hex) {
-                    this.buttonStates[key].buttons[i].hex = buttonState.buttons[i].hex;
+        if (stored) {
+            // Responses to ?since= only hold the changed buttons, match them by id
+            for (const button of buttonState.buttons) {
+                const target = stored.buttons.find(b => b.id === button.id);
+
+                if (target && button.hex) {
+                    target.hex = button.hex;
+                    target.pressed_at = button.pressed_at;
                     modified = true;
                 }
             }
@@ -370,8 +423,14 @@ class LocalState {
         }
 
         if (modified) {
-            this.buttonStates[key].next = buttonState.next;
+            stored.next = buttonState.next;
+        }
+
+        if (stored && buttonState.version > stored.version) {
+            stored.version = buttonState.version;
         }
+
+        return modified;
     }
 
     /**
@@ -417,6 +476,29 @@ class LocalState {
 
 /* HELPER FUNCTIONS */
 
+/**
+ * 
+ * @param {number} unixSeconds 
+ * @returns {string}
+ */
+function timeAgo(unixSeconds) {
+    const seconds = Math.max(0, Math.floor(Date.now() / 1000) - unixSeconds);
+    const units = [
+        ['day', 86400],
+        ['hour', 3600],
+        ['minute', 60],
+    ];
+
+    for (const [name, size] of units) {
+        const count = Math.floor(seconds / size);
+        if (count > 0) {
+            return `${count} ${name}${count === 1 ? '' : 's'} ago`;
+        }
+    }
+
+    return 'just now';
+}
+
 function generateRandomHex() {
     let hex = '';
 
@@ -536,6 +618,10 @@ function renderButtons(w, s, buttonState) {
             button.classList.add('pressed');
 
             button.style.color = `#${buttonState.buttons[i].hex}`;
+
+            if (buttonState.buttons[i].pressed_at) {
+                button.title = `Pressed ${timeAgo(buttonState.buttons[i].pressed_at)}`;
+            }
         } else {
             button.classList.remove('pressed');
         }
@@ -669,6 +755,32 @@ async function eventLoop(w, s) {
     }
 }
 
+/**
+ * Refreshes the visible pages, asking only for the buttons pressed since the
+ * version already held.
+ * 
+ * @param {Window} w 
+ * @param {LocalState} s 
+ */
+async function pollVisiblePages(w, s) {
+    const visible = [...w.document.querySelectorAll('.grid-container')].filter(elem => isInViewport(s, elem));
+
+    await Promise.all(visible.map(async (elem) => {
+        const { x, y } = getGridPoint(elem);
+        const buttonState = await s.retrieveButtonState(x, y);
+
+        if (!buttonState) {
+            return;
+        }
+
+        const changes = await s.api.getButtonsSince(x, y, buttonState.version);
+
+        if (changes && await s.storeButtonState(changes)) {
+            renderButtons(w, s, buttonState);
+        }
+    }));
+}
+
 /**
  * 
  * @param {Window} w 
@@ -722,7 +834,7 @@ async function startApplication(w, s) {
     if (!hash ||
         !hash.x || !hash.y ||
         hash.x < 1 || hash.y < 1 ||
-        hash.x > s.gridMaxX || hash.y > gridMaxY) {
+        hash.x > s.gridMaxX || hash.y > s.gridMaxY) {
         const toX = Math.ceil((Math.random() * 1000000) % s.gridMaxX);
         const toY = Math.ceil((Math.random() * 1000000) % s.gridMaxY);
 
@@ -809,6 +921,10 @@ async function startApplication(w, s) {
 
     /* Bad way to eal with screen proportions, find something better */
     s.eventInterval = w.setInterval(async () => await eventLoop(w, s,), 1000);
+
+    /* Navigating starts the application again */
+    w.clearInterval(s.pollInterval);
+    s.pollInterval = w.setInterval(async () => await pollVisiblePages(w, s), 5000);
 }
 
 /**
@@ -867,7 +983,7 @@ async function showControls(evt, w, s) {
 }
 
 async function showMap(evt, w, s) {
-    s.minimap.src = "./minimap.png";
+    s.minimap.src = s.api.minimapUrl;
     s.mapModal.showModal();
 }
 
@@ -878,7 +994,19 @@ const gridMaxY = parseInt(root.getPropertyValue('--button-grid-count-y'));
 window.state = window.state || new LocalState(window, gridMaxX, gridMaxY);
 window.state.panelTracker = new PanelTracker(window, (data) => onPanelStateChange(window, state, data));
 
-function bootstrap() {
+async function loadGridGeometry(w, s) {
+    const grid = await s.api.getGridGeometry().catch(() => null);
+
+    if (grid) {
+        s.gridMaxX = grid.cols;
+        s.gridMaxY = grid.rows;
+        w.document.documentElement.style.setProperty('--button-grid-count-x', grid.cols.toString());
+        w.document.documentElement.style.setProperty('--button-grid-count-y', grid.rows.toString());
+    }
+}
+
+async function bootstrap() {
+    await loadGridGeometry(window, state);
     startApplication(window, state);
     window.state.panelTracker.init();
 }
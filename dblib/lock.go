@@ -8,6 +8,10 @@ import (
 	"github.com/google/uuid"
 )
 
+// Taken by makedb expand and the app's admin API so only one expansion runs
+// at a time.
+const GRID_EXPAND_LOCK_TYPE = "grid_expand"
+
 var ErrLockNotAcquired = errors.New("lock has already been acquired")
 var ErrLockAlreadyReleased = errors.New("lock has already been released")
 
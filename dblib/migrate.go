@@ -0,0 +1,394 @@
+package dblib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The migrations are compiled into every binary that talks to the database,
+// so makedb and the app always agree on the schema they expect.
+//
+//go:embed migrations/*.sql migrations/down/*.sql
+var migrationFiles embed.FS
+
+const MIGRATIONS_DIR = "migrations"
+
+// MIGRATE_LOCK_TYPE guards schema changes so only one process migrates.
+const MIGRATE_LOCK_TYPE = "schema_migrate"
+
+// NO_TRANSACTION_MARKER starts a script that cannot run inside a
+// transaction, such as a single DO block that commits in batches.
+const NO_TRANSACTION_MARKER = "-- no-transaction\n"
+
+var ErrSchemaBehind = errors.New("database schema is behind the application")
+
+// Migration is one migrations/NNNN-name.sql script. Its paired down script
+// lives at migrations/down/NNNN-name.sql.
+type Migration struct {
+	Version  string
+	Name     string
+	Checksum string
+	UpFile   string
+	DownFile string
+}
+
+type AppliedMigration struct {
+	Version   string
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// LoadMigrations reads the embedded migration scripts, ordered by version.
+func LoadMigrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, MIGRATIONS_DIR)
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.sql"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(files)
+
+	migrations := make([]Migration, 0, len(files))
+
+	for _, file := range files {
+		name := path.Base(file)
+		version, _, found := strings.Cut(name, "-")
+
+		if !found {
+			return nil, fmt.Errorf("migration %s must be named <version>-<name>.sql", name)
+		}
+
+		if len(migrations) > 0 && migrations[len(migrations)-1].Version == version {
+			return nil, fmt.Errorf("migration version %s is used more than once", version)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		m := Migration{
+			Version:  version,
+			Name:     name,
+			Checksum: checksum(data),
+			UpFile:   file,
+		}
+
+		downFile := path.Join(dir, "down", name)
+
+		if _, err := fs.Stat(fsys, downFile); err == nil {
+			m.DownFile = downFile
+		}
+
+		migrations = append(migrations, m)
+	}
+
+	return migrations, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// EnsureMigrationTables creates the bookkeeping tables. sync_lock is also
+// created by a migration, but is needed to lock the very first run.
+func EnsureMigrationTables(dbc *sql.DB) error {
+	_, err := dbc.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version varchar(32) PRIMARY KEY,
+			name varchar(255) NOT NULL,
+			checksum char(64) NOT NULL,
+			applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = dbc.Exec(`
+		CREATE TABLE IF NOT EXISTS sync_lock (
+			id varchar(32) PRIMARY KEY,
+			lock_val UUID NULL,
+			lock_time TIMESTAMP NULL DEFAULT NULL
+		)`)
+
+	return err
+}
+
+func readAppliedMigrations(dbc *sql.DB) ([]AppliedMigration, error) {
+	rows, err := dbc.Query("SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	applied := make([]AppliedMigration, 0)
+
+	for rows.Next() {
+		a := AppliedMigration{}
+
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+
+		applied = append(applied, a)
+	}
+
+	return applied, rows.Err()
+}
+
+// verifyApplied refuses to go on when a migration that already ran has been
+// edited or removed, since the database no longer matches the scripts.
+func verifyApplied(migrations []Migration, applied []AppliedMigration) error {
+	for _, a := range applied {
+		if !slices.ContainsFunc(migrations, func(m Migration) bool { return m.Version == a.Version }) {
+			return fmt.Errorf("applied migration %s is missing from the migrations directory", a.Name)
+		}
+	}
+
+	return verifyChecksums(migrations, applied)
+}
+
+// verifyChecksums only checks the applied migrations that are known, so a
+// binary older than the database still passes.
+func verifyChecksums(migrations []Migration, applied []AppliedMigration) error {
+	for _, a := range applied {
+		ix := slices.IndexFunc(migrations, func(m Migration) bool { return m.Version == a.Version })
+
+		if ix >= 0 && migrations[ix].Checksum != a.Checksum {
+			return fmt.Errorf("migration %s has changed since it was applied", migrations[ix].Name)
+		}
+	}
+
+	return nil
+}
+
+func loadMigrationState(dbc *sql.DB) ([]Migration, []AppliedMigration, error) {
+	migrations, err := LoadMigrations()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := EnsureMigrationTables(dbc); err != nil {
+		return nil, nil, err
+	}
+
+	applied, err := readAppliedMigrations(dbc)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return migrations, applied, nil
+}
+
+func isApplied(applied []AppliedMigration, version string) bool {
+	return slices.ContainsFunc(applied, func(a AppliedMigration) bool { return a.Version == version })
+}
+
+// PendingMigrations returns the migrations not yet applied to the database,
+// or an error if an applied migration no longer matches its script.
+func PendingMigrations(dbc *sql.DB) ([]Migration, error) {
+	migrations, applied, err := loadMigrationState(dbc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyChecksums(migrations, applied); err != nil {
+		return nil, err
+	}
+
+	return pendingMigrations(migrations, applied), nil
+}
+
+func pendingMigrations(migrations []Migration, applied []AppliedMigration) []Migration {
+	pending := make([]Migration, 0)
+
+	for _, m := range migrations {
+		if !isApplied(applied, m.Version) {
+			pending = append(pending, m)
+		}
+	}
+
+	return pending
+}
+
+// MigrationStatus logs every migration and whether it has been applied.
+func MigrationStatus(dbc *sql.DB) error {
+	migrations, applied, err := loadMigrationState(dbc)
+
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		ix := slices.IndexFunc(applied, func(a AppliedMigration) bool { return a.Version == m.Version })
+
+		switch {
+		case ix < 0:
+			log.Printf("%-40s pending", m.Name)
+		case applied[ix].Checksum != m.Checksum:
+			log.Printf("%-40s CHANGED since applied at %v", m.Name, applied[ix].AppliedAt)
+		default:
+			log.Printf("%-40s applied at %v", m.Name, applied[ix].AppliedAt)
+		}
+	}
+
+	for _, a := range applied {
+		if !slices.ContainsFunc(migrations, func(m Migration) bool { return m.Version == a.Version }) {
+			log.Printf("%-40s MISSING, applied at %v", a.Name, a.AppliedAt)
+		}
+	}
+
+	return verifyApplied(migrations, applied)
+}
+
+// MigrateUp applies every pending migration in version order. Each script
+// runs in its own transaction together with its schema_migrations record.
+func MigrateUp(dbc *sql.DB, dryRun bool) error {
+	migrations, applied, err := loadMigrationState(dbc)
+
+	if err != nil {
+		return err
+	}
+
+	if err := verifyApplied(migrations, applied); err != nil {
+		return err
+	}
+
+	pending := pendingMigrations(migrations, applied)
+
+	for _, m := range pending {
+		if dryRun {
+			log.Printf("would apply %v", m.Name)
+			continue
+		}
+
+		start := time.Now()
+		log.Printf("applying %v...", m.Name)
+
+		err := execMigration(dbc, m.UpFile,
+			"INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+			m.Version, m.Name, m.Checksum)
+
+		if err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.Name, err)
+		}
+
+		log.Printf("applied %v... %v", m.Name, time.Since(start))
+	}
+
+	log.Printf("%d migrations pending", len(pending))
+	return nil
+}
+
+// MigrateDown rolls back the count most recently applied migrations using
+// their paired down scripts.
+func MigrateDown(dbc *sql.DB, count int, dryRun bool) error {
+	migrations, applied, err := loadMigrationState(dbc)
+
+	if err != nil {
+		return err
+	}
+
+	if err := verifyApplied(migrations, applied); err != nil {
+		return err
+	}
+
+	if count > len(applied) {
+		return fmt.Errorf("only %d migrations are applied", len(applied))
+	}
+
+	for i := len(applied) - 1; i >= len(applied)-count; i-- {
+		ix := slices.IndexFunc(migrations, func(m Migration) bool { return m.Version == applied[i].Version })
+		m := migrations[ix]
+
+		if len(m.DownFile) == 0 {
+			return fmt.Errorf("migration %s has no down script", m.Name)
+		}
+
+		if dryRun {
+			log.Printf("would roll back %v", m.Name)
+			continue
+		}
+
+		start := time.Now()
+		log.Printf("rolling back %v...", m.Name)
+
+		err := execMigration(dbc, m.DownFile, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+
+		if err != nil {
+			return fmt.Errorf("rollback of %s failed: %w", m.Name, err)
+		}
+
+		log.Printf("rolled back %v... %v", m.Name, time.Since(start))
+	}
+
+	return nil
+}
+
+// isNonTransactional reports whether a script opts out of the migration
+// transaction, so it can commit as it goes.
+func isNonTransactional(script []byte) bool {
+	return bytes.HasPrefix(script, []byte(NO_TRANSACTION_MARKER))
+}
+
+// execMigration runs a script and records it with the given statement in a
+// single transaction. A script marked with NO_TRANSACTION_MARKER runs on its
+// own and is only recorded once it succeeds, it must be safe to repeat.
+func execMigration(dbc *sql.DB, file string, record string, args ...any) error {
+	script, err := fs.ReadFile(migrationFiles, file)
+
+	if err != nil {
+		return err
+	}
+
+	if isNonTransactional(script) {
+		if _, err := dbc.Exec(string(script)); err != nil {
+			return err
+		}
+
+		_, err := dbc.Exec(record, args...)
+		return err
+	}
+
+	tx, err := dbc.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(string(script)); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(record, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
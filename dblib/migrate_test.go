@@ -0,0 +1,144 @@
+package dblib
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func migrationFS() fstest.MapFS {
+	return fstest.MapFS{
+		"migrations/0001-second.sql":      {Data: []byte("SELECT 2;")},
+		"migrations/0000-first.sql":       {Data: []byte("SELECT 1;")},
+		"migrations/down/0000-first.sql":  {Data: []byte("SELECT -1;")},
+		"migrations/notes.txt":            {Data: []byte("not a migration")},
+		"migrations/down/0001-second.txt": {Data: []byte("not a down script")},
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFS(), MIGRATIONS_DIR)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(migrations) != 2 || migrations[0].Version != "0000" || migrations[1].Version != "0001" {
+		t.Fatalf("expected migrations in version order, got %+v", migrations)
+	}
+
+	first := migrations[0]
+
+	if first.Name != "0000-first.sql" || first.UpFile != "migrations/0000-first.sql" || first.DownFile != "migrations/down/0000-first.sql" {
+		t.Errorf("unexpected first migration %+v", first)
+	}
+
+	if first.Checksum != checksum([]byte("SELECT 1;")) {
+		t.Errorf("expected the checksum of the script, got %s", first.Checksum)
+	}
+
+	if migrations[1].DownFile != "" {
+		t.Errorf("expected no down script, got %s", migrations[1].DownFile)
+	}
+}
+
+func TestLoadMigrationsRejectsBadNames(t *testing.T) {
+	invalid := map[string]fstest.MapFS{
+		"unversioned": {
+			"migrations/first.sql": {Data: []byte("SELECT 1;")},
+		},
+		"duplicate version": {
+			"migrations/0000-first.sql": {Data: []byte("SELECT 1;")},
+			"migrations/0000-again.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+
+	for name, fsys := range invalid {
+		if _, err := loadMigrations(fsys, MIGRATIONS_DIR); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestEmbeddedMigrationsHaveDownScripts(t *testing.T) {
+	migrations, err := LoadMigrations()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, m := range migrations {
+		if m.DownFile == "" {
+			t.Errorf("migration %s has no down script", m.Name)
+		}
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFS(), MIGRATIONS_DIR)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if pending := pendingMigrations(migrations, nil); len(pending) != 2 {
+		t.Errorf("expected every migration to be pending, got %+v", pending)
+	}
+
+	applied := []AppliedMigration{{Version: "0000", Name: "0000-first.sql", Checksum: migrations[0].Checksum}}
+	pending := pendingMigrations(migrations, applied)
+
+	if len(pending) != 1 || pending[0].Version != "0001" {
+		t.Errorf("expected 0001 to be pending, got %+v", pending)
+	}
+
+	applied = append(applied, AppliedMigration{Version: "0001", Name: "0001-second.sql", Checksum: migrations[1].Checksum})
+
+	if pending := pendingMigrations(migrations, applied); len(pending) != 0 {
+		t.Errorf("expected nothing pending, got %+v", pending)
+	}
+}
+
+func TestVerifyApplied(t *testing.T) {
+	migrations, err := loadMigrations(migrationFS(), MIGRATIONS_DIR)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	current := []AppliedMigration{{Version: "0000", Name: "0000-first.sql", Checksum: migrations[0].Checksum}}
+
+	if err := verifyApplied(migrations, current); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	edited := []AppliedMigration{{Version: "0000", Name: "0000-first.sql", Checksum: checksum([]byte("SELECT 0;"))}}
+
+	if err := verifyApplied(migrations, edited); err == nil {
+		t.Error("expected an edited migration to be refused")
+	}
+
+	if err := verifyChecksums(migrations, edited); err == nil {
+		t.Error("expected the checksums of an edited migration to be refused")
+	}
+
+	// A binary older than the database does not know the newest migration
+	newer := append(current, AppliedMigration{Version: "0002", Name: "0002-third.sql", Checksum: checksum([]byte("SELECT 3;"))})
+
+	if err := verifyChecksums(migrations, newer); err != nil {
+		t.Errorf("expected unknown migrations to pass the checksums, got %v", err)
+	}
+
+	if err := verifyApplied(migrations, newer); err == nil {
+		t.Error("expected a missing migration to be refused")
+	}
+}
+
+func TestNonTransactionalMarker(t *testing.T) {
+	if !isNonTransactional([]byte("-- no-transaction\n\nDO $$ BEGIN COMMIT; END $$;")) {
+		t.Error("expected a marked script to run outside a transaction")
+	}
+
+	if isNonTransactional([]byte("DO $$ BEGIN END $$;\n-- no-transaction\n")) {
+		t.Error("expected the marker to count only on the first line")
+	}
+}
@@ -0,0 +1,39 @@
+DO $$
+BEGIN
+
+/*
+ * pressed_at holds one 4 byte big-endian unix timestamp (seconds) per button,
+ * in the same order as the buttons column. Zero means never pressed.
+ */
+ALTER TABLE button
+    ADD COLUMN IF NOT EXISTS pressed_at bytea NOT NULL DEFAULT decode(repeat('00', 400), 'hex');
+
+CREATE OR REPLACE PROCEDURE set_button_color(
+    x INTEGER,
+    y INTEGER,
+    ix INTEGER,
+    rgbVal BYTEA)
+AS $BODY$
+DECLARE
+    ixs INTEGER;
+    rV INTEGER := get_byte(rgbVal, 0);
+    gV INTEGER := get_byte(rgbVal, 1);
+    bV INTEGER := get_byte(rgbVal, 2);
+BEGIN
+
+ixs := ix * 3;
+
+UPDATE button SET 
+    buttons = set_byte(set_byte(set_byte(buttons, ixs + 2, bV), ixs + 1, gV), ixs, rV)
+    ,version = version + 1
+    ,map_value = get_minimap_color(buttons)
+    ,pressed_at = overlay(pressed_at PLACING int4send(extract(epoch FROM now())::int) FROM (ix * 4) + 1 FOR 4)
+WHERE 
+    x_coord = x AND
+    y_coord = y AND
+    substring(buttons FROM (ixs+1) FOR 3) = '\x000000';
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
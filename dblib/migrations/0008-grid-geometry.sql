@@ -0,0 +1,84 @@
+DO $$
+BEGIN
+
+/*
+ * Grid geometry: cols x rows pages of buttons_per_page buttons. It starts as
+ * the grid the schema was created with, makedb create resizes the pages with
+ * set_page_size while the grid is still empty.
+ */
+CREATE TABLE IF NOT EXISTS grid_geometry (
+    id int PRIMARY KEY CHECK (id = 1),
+    cols int NOT NULL CHECK (cols > 0),
+    rows int NOT NULL CHECK (rows > 0),
+    buttons_per_page int NOT NULL CHECK (buttons_per_page > 0)
+);
+
+INSERT INTO grid_geometry (id, cols, rows, buttons_per_page)
+VALUES (1, 4096, 2500, 100)
+ON CONFLICT (id) DO NOTHING;
+
+CREATE OR REPLACE FUNCTION get_minimap_color(bytes fixed_bytea)
+RETURNS bytea
+AS $BODY$
+DECLARE
+    cr INTEGER := 0;
+    cg INTEGER := 0;
+    cb INTEGER := 0;
+    buttonCount INTEGER := octet_length(bytes) / 3;
+BEGIN
+
+FOR i IN 0..(octet_length(bytes) - 3) BY 3 LOOP
+    cr = cr + get_byte(bytes, i);
+    cg = cg + get_byte(bytes, i+1);
+    cb = cb + get_byte(bytes, i+2);
+END LOOP;
+
+    cr = cr / buttonCount;
+    cg = cg / buttonCount;
+    cb = cb / buttonCount;
+
+    RETURN to_hex(cr) || to_hex(cg) || to_hex(cb);
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS set_page_size;
+
+/*
+ * Example: call set_page_size(64);
+ * Resizes fixed_bytea and the per-button columns to buttonsPerPage buttons.
+ * Existing pages are never rewritten, so this only runs on an empty grid.
+ */
+CREATE OR REPLACE PROCEDURE set_page_size(buttonsPerPage INTEGER)
+AS $BODY$
+BEGIN
+
+IF buttonsPerPage = (SELECT buttons_per_page FROM grid_geometry WHERE id = 1) THEN
+    RETURN;
+END IF;
+
+IF EXISTS (SELECT 1 FROM button) THEN
+    RAISE EXCEPTION 'pages can only be resized on an empty grid';
+END IF;
+
+UPDATE grid_geometry SET buttons_per_page = buttonsPerPage WHERE id = 1;
+
+ALTER DOMAIN fixed_bytea DROP CONSTRAINT fixed_length;
+
+EXECUTE format(
+    'ALTER DOMAIN fixed_bytea ADD CONSTRAINT fixed_length CHECK (octet_length(value) = %s)',
+    buttonsPerPage * 3);
+
+EXECUTE format(
+    'ALTER DOMAIN fixed_bytea SET DEFAULT decode(repeat(''00'', %s), ''hex'')',
+    buttonsPerPage * 3);
+
+EXECUTE format(
+    'ALTER TABLE button
+        ALTER COLUMN pressed_at SET DEFAULT decode(repeat(''00'', %1$s), ''hex''),
+        ALTER COLUMN pressed_version SET DEFAULT decode(repeat(''00'', %1$s), ''hex'')',
+    buttonsPerPage * 4);
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
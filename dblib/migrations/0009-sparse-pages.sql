@@ -0,0 +1,51 @@
+DO $$
+BEGIN
+
+/*
+ * Pages are materialised on their first press. Drop pages that were seeded
+ * but never pressed, they read exactly the same as missing pages.
+ */
+DELETE FROM button WHERE version = 0;
+
+CREATE OR REPLACE PROCEDURE set_button_color(
+    x INTEGER,
+    y INTEGER,
+    ix INTEGER,
+    rgbVal BYTEA)
+AS $BODY$
+DECLARE
+    ixs INTEGER;
+    rV INTEGER := get_byte(rgbVal, 0);
+    gV INTEGER := get_byte(rgbVal, 1);
+    bV INTEGER := get_byte(rgbVal, 2);
+    gridCols INTEGER;
+    gridRows INTEGER;
+BEGIN
+
+SELECT cols, rows INTO gridCols, gridRows FROM grid_geometry WHERE id = 1;
+
+IF x < 1 OR y < 1 OR x > gridCols OR y > gridRows THEN
+    RETURN;
+END IF;
+
+INSERT INTO button (x_coord, y_coord)
+VALUES (x, y)
+ON CONFLICT (x_coord, y_coord) DO NOTHING;
+
+ixs := ix * 3;
+
+UPDATE button SET 
+    buttons = set_byte(set_byte(set_byte(buttons, ixs + 2, bV), ixs + 1, gV), ixs, rV)
+    ,version = version + 1
+    ,map_value = get_minimap_color(buttons)
+    ,pressed_at = overlay(pressed_at PLACING int4send(extract(epoch FROM now())::int) FROM (ix * 4) + 1 FOR 4)
+    ,pressed_version = overlay(pressed_version PLACING int4send(version + 1) FROM (ix * 4) + 1 FOR 4)
+WHERE 
+    x_coord = x AND
+    y_coord = y AND
+    substring(buttons FROM (ixs+1) FOR 3) = '\x000000';
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
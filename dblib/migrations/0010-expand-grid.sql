@@ -0,0 +1,54 @@
+DO $$
+BEGIN
+
+/*
+ * Global button ids are numbered id_cols pages to a row, so adding columns
+ * never renumbers existing buttons. Columns can only grow up to id_cols,
+ * rows grow freely.
+ */
+ALTER TABLE grid_geometry
+    ADD COLUMN IF NOT EXISTS id_cols int NULL;
+
+UPDATE grid_geometry SET id_cols = cols WHERE id_cols IS NULL;
+
+ALTER TABLE grid_geometry
+    ALTER COLUMN id_cols SET NOT NULL;
+
+IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'grid_geometry_id_cols_check') THEN
+    ALTER TABLE grid_geometry ADD CONSTRAINT grid_geometry_id_cols_check CHECK (cols <= id_cols);
+END IF;
+
+DROP PROCEDURE IF EXISTS expand_grid;
+
+/*
+ * Example: call expand_grid(16, 0);
+ * Appends add_cols columns and add_rows rows of pages. Pages are sparse, so
+ * this only moves the bounds recorded in grid_geometry.
+ */
+CREATE OR REPLACE PROCEDURE expand_grid(
+    add_cols INTEGER,
+    add_rows INTEGER)
+AS $BODY$
+DECLARE
+    live grid_geometry%ROWTYPE;
+BEGIN
+
+IF add_cols < 0 OR add_rows < 0 THEN
+    RAISE EXCEPTION 'grid can only grow, got % columns and % rows', add_cols, add_rows;
+END IF;
+
+SELECT * INTO live FROM grid_geometry WHERE id = 1 FOR UPDATE;
+
+IF live.cols + add_cols > live.id_cols THEN
+    RAISE EXCEPTION 'grid has button ids for % columns, cannot grow to %', live.id_cols, live.cols + add_cols;
+END IF;
+
+UPDATE grid_geometry SET
+    cols = cols + add_cols,
+    rows = rows + add_rows
+WHERE id = 1;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
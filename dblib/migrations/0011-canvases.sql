@@ -0,0 +1,224 @@
+DO $$
+BEGIN
+
+/*
+ * Canvases are independent grids sharing one database. Every canvas has its
+ * own bounds and button id stride, while the page size in grid_geometry is shared by all of them
+ * because fixed_bytea is sized from it. The canvas 'main' always exists and
+ * is the one served by the unscoped routes.
+ */
+CREATE TABLE IF NOT EXISTS canvas (
+    name varchar(20) PRIMARY KEY CHECK (name ~ '^[a-z0-9-]+$'),
+    cols int NOT NULL CHECK (cols > 0),
+    rows int NOT NULL CHECK (rows > 0),
+    id_cols int NOT NULL,
+    created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK (cols <= id_cols)
+);
+
+INSERT INTO canvas (name, cols, rows, id_cols)
+SELECT 'main', cols, rows, id_cols FROM grid_geometry WHERE id = 1
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE button
+    ADD COLUMN IF NOT EXISTS canvas varchar(20) NOT NULL DEFAULT 'main';
+
+ALTER TABLE button_event
+    ADD COLUMN IF NOT EXISTS canvas varchar(20) NOT NULL DEFAULT 'main';
+
+ALTER TABLE button_stat
+    ADD COLUMN IF NOT EXISTS canvas varchar(20) NOT NULL DEFAULT 'main';
+
+IF NOT EXISTS (SELECT 1 FROM information_schema.key_column_usage
+               WHERE table_name = 'button' AND constraint_name = 'button_pkey' AND column_name = 'canvas') THEN
+    ALTER TABLE button DROP CONSTRAINT button_pkey;
+    ALTER TABLE button ADD PRIMARY KEY (canvas, x_coord, y_coord);
+END IF;
+
+IF NOT EXISTS (SELECT 1 FROM information_schema.key_column_usage
+               WHERE table_name = 'button_stat' AND constraint_name = 'button_stat_pkey' AND column_name = 'canvas') THEN
+    ALTER TABLE button_stat DROP CONSTRAINT button_stat_pkey;
+    ALTER TABLE button_stat ADD PRIMARY KEY (canvas, stat_key);
+END IF;
+
+CREATE INDEX IF NOT EXISTS idx_button_event_canvas_event_type
+    ON button_event (canvas, event_type, created_at);
+
+DROP PROCEDURE IF EXISTS set_button_color(INTEGER, INTEGER, INTEGER, BYTEA);
+DROP PROCEDURE IF EXISTS set_button_color(VARCHAR, INTEGER, INTEGER, INTEGER, BYTEA);
+
+/*
+ * Example: call set_button_color ('main', 1, 1, 56, '\xFFAB03');
+ */
+CREATE OR REPLACE PROCEDURE set_button_color(
+    canvasName VARCHAR,
+    x INTEGER,
+    y INTEGER,
+    ix INTEGER,
+    rgbVal BYTEA)
+AS $BODY$
+DECLARE
+    ixs INTEGER;
+    rV INTEGER := get_byte(rgbVal, 0);
+    gV INTEGER := get_byte(rgbVal, 1);
+    bV INTEGER := get_byte(rgbVal, 2);
+    gridCols INTEGER;
+    gridRows INTEGER;
+BEGIN
+
+SELECT cols, rows INTO gridCols, gridRows FROM canvas WHERE name = canvasName;
+
+IF gridCols IS NULL OR x < 1 OR y < 1 OR x > gridCols OR y > gridRows THEN
+    RETURN;
+END IF;
+
+INSERT INTO button (canvas, x_coord, y_coord)
+VALUES (canvasName, x, y)
+ON CONFLICT (canvas, x_coord, y_coord) DO NOTHING;
+
+ixs := ix * 3;
+
+UPDATE button SET 
+    buttons = set_byte(set_byte(set_byte(buttons, ixs + 2, bV), ixs + 1, gV), ixs, rV)
+    ,version = version + 1
+    ,map_value = get_minimap_color(buttons)
+    ,pressed_at = overlay(pressed_at PLACING int4send(extract(epoch FROM now())::int) FROM (ix * 4) + 1 FOR 4)
+    ,pressed_version = overlay(pressed_version PLACING int4send(version + 1) FROM (ix * 4) + 1 FOR 4)
+WHERE 
+    canvas = canvasName AND
+    x_coord = x AND
+    y_coord = y AND
+    substring(buttons FROM (ixs+1) FOR 3) = '\x000000';
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS expand_grid(INTEGER, INTEGER);
+DROP PROCEDURE IF EXISTS expand_grid(VARCHAR, INTEGER, INTEGER);
+
+/*
+ * Example: call expand_grid('main', 16, 0);
+ */
+CREATE OR REPLACE PROCEDURE expand_grid(
+    canvasName VARCHAR,
+    add_cols INTEGER,
+    add_rows INTEGER)
+AS $BODY$
+DECLARE
+    live canvas%ROWTYPE;
+BEGIN
+
+IF add_cols < 0 OR add_rows < 0 THEN
+    RAISE EXCEPTION 'grid can only grow, got % columns and % rows', add_cols, add_rows;
+END IF;
+
+SELECT * INTO live FROM canvas WHERE name = canvasName FOR UPDATE;
+
+IF NOT FOUND THEN
+    RAISE EXCEPTION 'canvas % does not exist', canvasName;
+END IF;
+
+IF live.cols + add_cols > live.id_cols THEN
+    RAISE EXCEPTION 'canvas % has button ids for % columns, cannot grow to %', canvasName, live.id_cols, live.cols + add_cols;
+END IF;
+
+UPDATE canvas SET 
+    cols = cols + add_cols,
+    rows = rows + add_rows 
+WHERE name = canvasName;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS create_canvas;
+
+/*
+ * Example: call create_canvas('event', 64, 64, 256);
+ * idCols reserves button ids for that many columns, the canvas can later
+ * grow up to it. It defaults to gridCols.
+ * Stats are seeded from the definitions of the main canvas.
+ */
+CREATE OR REPLACE PROCEDURE create_canvas(
+    canvasName VARCHAR,
+    gridCols INTEGER,
+    gridRows INTEGER,
+    idCols INTEGER DEFAULT NULL)
+AS $BODY$
+BEGIN
+
+INSERT INTO canvas (name, cols, rows, id_cols)
+VALUES (canvasName, gridCols, gridRows, COALESCE(idCols, gridCols));
+
+INSERT INTO button_stat (canvas, stat_key, stat_name, stat_desc, val, "scale", "order")
+SELECT canvasName, stat_key, stat_name, stat_desc, 0, "scale", "order"
+FROM button_stat
+WHERE canvas = 'main'
+ON CONFLICT DO NOTHING;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS drop_canvas;
+
+/*
+ * Example: call drop_canvas('event');
+ * Removes a canvas and all of its pages, events and stats.
+ */
+CREATE OR REPLACE PROCEDURE drop_canvas(canvasName VARCHAR)
+AS $BODY$
+BEGIN
+
+IF canvasName = 'main' THEN
+    RAISE EXCEPTION 'the main canvas cannot be dropped';
+END IF;
+
+DELETE FROM button WHERE canvas = canvasName;
+DELETE FROM button_event WHERE canvas = canvasName;
+DELETE FROM button_stat WHERE canvas = canvasName;
+DELETE FROM canvas WHERE name = canvasName;
+
+IF NOT FOUND THEN
+    RAISE EXCEPTION 'canvas % does not exist', canvasName;
+END IF;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS update_button_stats;
+
+CREATE OR REPLACE PROCEDURE update_button_stats()
+AS $BODY$
+BEGIN
+
+UPDATE button_stat s SET val = (
+    SELECT COUNT(*) 
+    FROM button_event e
+    WHERE e.event_type = 'press'
+    AND e.canvas = s.canvas
+) 
+WHERE stat_key = 'buttons_pressed';
+
+
+UPDATE button_stat s SET val = (
+    SELECT COUNT(*) 
+    FROM button_event e
+    WHERE e.event_type = 'press'
+    AND e.canvas = s.canvas
+    AND e.created_at >= NOW() - INTERVAL '1 day'
+) 
+WHERE stat_key = 'pressed_last_day';
+
+
+UPDATE button_stat s SET val = (
+    SELECT COUNT(*) * 1000 / 600
+    FROM button_event e
+    WHERE e.event_type = 'press'
+    AND e.canvas = s.canvas
+    AND e.created_at >= NOW() - INTERVAL '10 minutes'
+) 
+WHERE stat_key = 'presses_per_second';
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
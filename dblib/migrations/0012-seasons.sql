@@ -0,0 +1,148 @@
+DO $$
+BEGIN
+
+/*
+ * A season ends by moving a canvas' pages and events into a read-only
+ * archive canvas named '<canvas>-s<season>', which keeps its final stats.
+ */
+ALTER TABLE canvas
+    ADD COLUMN IF NOT EXISTS season int NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS read_only boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS archived_from varchar(20) NULL,
+    ADD COLUMN IF NOT EXISTS archived_at timestamp NULL;
+
+DROP PROCEDURE IF EXISTS start_season;
+
+/*
+ * Example: call start_season('main');
+ * The archive keeps the id stride, and so the button ids, of the canvas.
+ */
+CREATE OR REPLACE PROCEDURE start_season(canvasName VARCHAR)
+AS $BODY$
+DECLARE
+    live canvas%ROWTYPE;
+    archiveName VARCHAR;
+BEGIN
+
+SELECT * INTO live FROM canvas WHERE name = canvasName FOR UPDATE;
+
+IF NOT FOUND THEN
+    RAISE EXCEPTION 'canvas % does not exist', canvasName;
+END IF;
+
+IF live.read_only THEN
+    RAISE EXCEPTION 'canvas % is an archive', canvasName;
+END IF;
+
+archiveName := canvasName || '-s' || live.season;
+
+INSERT INTO canvas (name, cols, rows, id_cols, season, read_only, archived_from, archived_at)
+VALUES (archiveName, live.cols, live.rows, live.id_cols, live.season, true, canvasName, CURRENT_TIMESTAMP);
+
+UPDATE button SET canvas = archiveName WHERE canvas = canvasName;
+UPDATE button_event SET canvas = archiveName WHERE canvas = canvasName;
+
+INSERT INTO button_stat (canvas, stat_key, stat_name, stat_desc, val, "scale", "order")
+SELECT archiveName, stat_key, stat_name, stat_desc, val, "scale", "order"
+FROM button_stat
+WHERE canvas = canvasName;
+
+UPDATE button_stat SET val = 0 WHERE canvas = canvasName;
+UPDATE canvas SET season = season + 1 WHERE name = canvasName;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS set_button_color(VARCHAR, INTEGER, INTEGER, INTEGER, BYTEA);
+
+/*
+ * Example: call set_button_color ('main', 1, 1, 56, '\xFFAB03');
+ */
+CREATE OR REPLACE PROCEDURE set_button_color(
+    canvasName VARCHAR,
+    x INTEGER,
+    y INTEGER,
+    ix INTEGER,
+    rgbVal BYTEA)
+AS $BODY$
+DECLARE
+    ixs INTEGER;
+    rV INTEGER := get_byte(rgbVal, 0);
+    gV INTEGER := get_byte(rgbVal, 1);
+    bV INTEGER := get_byte(rgbVal, 2);
+    gridCols INTEGER;
+    gridRows INTEGER;
+    isReadOnly BOOLEAN;
+BEGIN
+
+SELECT cols, rows, read_only INTO gridCols, gridRows, isReadOnly FROM canvas WHERE name = canvasName;
+
+IF gridCols IS NULL OR isReadOnly OR x < 1 OR y < 1 OR x > gridCols OR y > gridRows THEN
+    RETURN;
+END IF;
+
+INSERT INTO button (canvas, x_coord, y_coord)
+VALUES (canvasName, x, y)
+ON CONFLICT (canvas, x_coord, y_coord) DO NOTHING;
+
+ixs := ix * 3;
+
+UPDATE button SET 
+    buttons = set_byte(set_byte(set_byte(buttons, ixs + 2, bV), ixs + 1, gV), ixs, rV)
+    ,version = version + 1
+    ,map_value = get_minimap_color(buttons)
+    ,pressed_at = overlay(pressed_at PLACING int4send(extract(epoch FROM now())::int) FROM (ix * 4) + 1 FOR 4)
+    ,pressed_version = overlay(pressed_version PLACING int4send(version + 1) FROM (ix * 4) + 1 FOR 4)
+WHERE 
+    canvas = canvasName AND
+    x_coord = x AND
+    y_coord = y AND
+    substring(buttons FROM (ixs+1) FOR 3) = '\x000000';
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS update_button_stats;
+
+/*
+ * Stats of archived canvases are frozen at the end of their season.
+ */
+CREATE OR REPLACE PROCEDURE update_button_stats()
+AS $BODY$
+BEGIN
+
+UPDATE button_stat s SET val = (
+    SELECT COUNT(*) 
+    FROM button_event e
+    WHERE e.event_type = 'press'
+    AND e.canvas = s.canvas
+) 
+WHERE stat_key = 'buttons_pressed'
+AND s.canvas IN (SELECT name FROM canvas WHERE NOT read_only);
+
+
+UPDATE button_stat s SET val = (
+    SELECT COUNT(*) 
+    FROM button_event e
+    WHERE e.event_type = 'press'
+    AND e.canvas = s.canvas
+    AND e.created_at >= NOW() - INTERVAL '1 day'
+) 
+WHERE stat_key = 'pressed_last_day'
+AND s.canvas IN (SELECT name FROM canvas WHERE NOT read_only);
+
+
+UPDATE button_stat s SET val = (
+    SELECT COUNT(*) * 1000 / 600
+    FROM button_event e
+    WHERE e.event_type = 'press'
+    AND e.canvas = s.canvas
+    AND e.created_at >= NOW() - INTERVAL '10 minutes'
+) 
+WHERE stat_key = 'presses_per_second'
+AND s.canvas IN (SELECT name FROM canvas WHERE NOT read_only);
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
@@ -0,0 +1,78 @@
+DO $$
+BEGIN
+
+/*
+ * change_seq orders page writes across the whole table. Versions only count
+ * presses within a page, so the minimap worker asks for the pages changed
+ * since the highest change_seq it has drawn instead.
+ */
+CREATE SEQUENCE IF NOT EXISTS button_change_seq;
+
+/*
+ * A volatile default on ADD COLUMN would rewrite the whole table under an
+ * exclusive lock. The column is added empty and only new pages take the
+ * default, 0015-change-seq-not-null.sql numbers the existing ones in batches.
+ */
+ALTER TABLE button
+    ADD COLUMN IF NOT EXISTS change_seq BIGINT NULL;
+
+ALTER TABLE button
+    ALTER COLUMN change_seq SET DEFAULT nextval('button_change_seq');
+
+CREATE INDEX IF NOT EXISTS button_canvas_change_seq_idx ON button (canvas, change_seq);
+
+DROP PROCEDURE IF EXISTS set_button_color(VARCHAR, INTEGER, INTEGER, INTEGER, BYTEA);
+
+/*
+ * Example: call set_button_color ('main', 1, 1, 56, '\xFFAB03');
+ */
+CREATE OR REPLACE PROCEDURE set_button_color(
+    canvasName VARCHAR,
+    x INTEGER,
+    y INTEGER,
+    ix INTEGER,
+    rgbVal BYTEA)
+AS $BODY$
+DECLARE
+    ixs INTEGER;
+    rV INTEGER := get_byte(rgbVal, 0);
+    gV INTEGER := get_byte(rgbVal, 1);
+    bV INTEGER := get_byte(rgbVal, 2);
+    gridCols INTEGER;
+    gridRows INTEGER;
+    isReadOnly BOOLEAN;
+BEGIN
+
+SELECT cols, rows, read_only INTO gridCols, gridRows, isReadOnly FROM canvas WHERE name = canvasName;
+
+IF gridCols IS NULL OR isReadOnly OR x < 1 OR y < 1 OR x > gridCols OR y > gridRows THEN
+    RETURN;
+END IF;
+
+INSERT INTO button (canvas, x_coord, y_coord)
+VALUES (canvasName, x, y)
+ON CONFLICT (canvas, x_coord, y_coord) DO NOTHING;
+
+ixs := ix * 3;
+
+/*
+ * Every SET expression sees the row before the update, so map_value is
+ * computed from the new buttons rather than from the buttons column.
+ */
+UPDATE button SET 
+    buttons = set_byte(set_byte(set_byte(buttons, ixs + 2, bV), ixs + 1, gV), ixs, rV)
+    ,version = version + 1
+    ,map_value = get_minimap_color(set_byte(set_byte(set_byte(buttons, ixs + 2, bV), ixs + 1, gV), ixs, rV)::fixed_bytea)
+    ,pressed_at = overlay(pressed_at PLACING int4send(extract(epoch FROM now())::int) FROM (ix * 4) + 1 FOR 4)
+    ,pressed_version = overlay(pressed_version PLACING int4send(version + 1) FROM (ix * 4) + 1 FOR 4)
+    ,change_seq = nextval('button_change_seq')
+WHERE 
+    canvas = canvasName AND
+    x_coord = x AND
+    y_coord = y AND
+    substring(buttons FROM (ixs+1) FOR 3) = '\x000000';
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
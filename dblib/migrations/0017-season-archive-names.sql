@@ -0,0 +1,95 @@
+DO $$
+BEGIN
+
+/*
+ * Season archives are named '<canvas>-s<season>' and canvas names are at most
+ * 20 characters. New canvases leave room for the suffix and cannot take a
+ * name of that shape, and start_season checks its archive name up front.
+ */
+DROP PROCEDURE IF EXISTS create_canvas(VARCHAR, INTEGER, INTEGER, INTEGER);
+
+/*
+ * Example: call create_canvas('event', 64, 64, 256);
+ * idCols reserves button ids for that many columns, the canvas can later
+ * grow up to it. It defaults to gridCols.
+ * Stats are seeded from the definitions of the main canvas.
+ */
+CREATE OR REPLACE PROCEDURE create_canvas(
+    canvasName VARCHAR,
+    gridCols INTEGER,
+    gridRows INTEGER,
+    idCols INTEGER DEFAULT NULL)
+AS $BODY$
+BEGIN
+
+IF canvasName ~ '-s[0-9]+$' THEN
+    RAISE EXCEPTION 'canvas names ending in -s<season> are reserved for season archives, got %', canvasName;
+END IF;
+
+IF length(canvasName) > 15 THEN
+    RAISE EXCEPTION 'canvas name % is longer than 15 characters, which leaves no room for its season archives', canvasName;
+END IF;
+
+INSERT INTO canvas (name, cols, rows, id_cols)
+VALUES (canvasName, gridCols, gridRows, COALESCE(idCols, gridCols));
+
+INSERT INTO button_stat (canvas, stat_key, stat_name, stat_desc, val, "scale", "order")
+SELECT canvasName, stat_key, stat_name, stat_desc, 0, "scale", "order"
+FROM button_stat
+WHERE canvas = 'main'
+ON CONFLICT DO NOTHING;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS start_season;
+
+/*
+ * Example: call start_season('main');
+ * The archive keeps the id stride, and so the button ids, of the canvas.
+ */
+CREATE OR REPLACE PROCEDURE start_season(canvasName VARCHAR)
+AS $BODY$
+DECLARE
+    live canvas%ROWTYPE;
+    archiveName VARCHAR;
+BEGIN
+
+SELECT * INTO live FROM canvas WHERE name = canvasName FOR UPDATE;
+
+IF NOT FOUND THEN
+    RAISE EXCEPTION 'canvas % does not exist', canvasName;
+END IF;
+
+IF live.read_only THEN
+    RAISE EXCEPTION 'canvas % is an archive', canvasName;
+END IF;
+
+archiveName := canvasName || '-s' || live.season;
+
+IF length(archiveName) > 20 THEN
+    RAISE EXCEPTION 'season archive name % is longer than 20 characters', archiveName;
+END IF;
+
+IF EXISTS (SELECT 1 FROM canvas WHERE name = archiveName) THEN
+    RAISE EXCEPTION 'season archive name % is already taken by another canvas', archiveName;
+END IF;
+
+INSERT INTO canvas (name, cols, rows, id_cols, season, read_only, archived_from, archived_at)
+VALUES (archiveName, live.cols, live.rows, live.id_cols, live.season, true, canvasName, CURRENT_TIMESTAMP);
+
+UPDATE button SET canvas = archiveName WHERE canvas = canvasName;
+UPDATE button_event SET canvas = archiveName WHERE canvas = canvasName;
+
+INSERT INTO button_stat (canvas, stat_key, stat_name, stat_desc, val, "scale", "order")
+SELECT archiveName, stat_key, stat_name, stat_desc, val, "scale", "order"
+FROM button_stat
+WHERE canvas = canvasName;
+
+UPDATE button_stat SET val = 0 WHERE canvas = canvasName;
+UPDATE canvas SET season = season + 1 WHERE name = canvasName;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
@@ -0,0 +1,62 @@
+DO $$
+BEGIN
+
+/*
+ * start_season raises an archive name that is too long or already taken
+ * with its own SQLSTATE, so callers can tell a name conflict from a failure.
+ * A canvas created under the archive name concurrently still fails the
+ * insert with unique_violation.
+ */
+DROP PROCEDURE IF EXISTS start_season;
+
+/*
+ * Example: call start_season('main');
+ * The archive keeps the id stride, and so the button ids, of the canvas.
+ */
+CREATE OR REPLACE PROCEDURE start_season(canvasName VARCHAR)
+AS $BODY$
+DECLARE
+    live canvas%ROWTYPE;
+    archiveName VARCHAR;
+BEGIN
+
+SELECT * INTO live FROM canvas WHERE name = canvasName FOR UPDATE;
+
+IF NOT FOUND THEN
+    RAISE EXCEPTION 'canvas % does not exist', canvasName;
+END IF;
+
+IF live.read_only THEN
+    RAISE EXCEPTION 'canvas % is an archive', canvasName;
+END IF;
+
+archiveName := canvasName || '-s' || live.season;
+
+IF length(archiveName) > 20 THEN
+    RAISE EXCEPTION 'season archive name % is longer than 20 characters', archiveName
+        USING ERRCODE = 'name_too_long';
+END IF;
+
+IF EXISTS (SELECT 1 FROM canvas WHERE name = archiveName) THEN
+    RAISE EXCEPTION 'season archive name % is already taken by another canvas', archiveName
+        USING ERRCODE = 'unique_violation';
+END IF;
+
+INSERT INTO canvas (name, cols, rows, id_cols, season, read_only, archived_from, archived_at)
+VALUES (archiveName, live.cols, live.rows, live.id_cols, live.season, true, canvasName, CURRENT_TIMESTAMP);
+
+UPDATE button SET canvas = archiveName WHERE canvas = canvasName;
+UPDATE button_event SET canvas = archiveName WHERE canvas = canvasName;
+
+INSERT INTO button_stat (canvas, stat_key, stat_name, stat_desc, val, "scale", "order")
+SELECT archiveName, stat_key, stat_name, stat_desc, val, "scale", "order"
+FROM button_stat
+WHERE canvas = canvasName;
+
+UPDATE button_stat SET val = 0 WHERE canvas = canvasName;
+UPDATE canvas SET season = season + 1 WHERE name = canvasName;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
@@ -0,0 +1,8 @@
+DO $$
+BEGIN
+
+DROP TABLE IF EXISTS button;
+
+DROP DOMAIN IF EXISTS fixed_bytea;
+
+END $$;
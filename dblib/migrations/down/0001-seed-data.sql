@@ -0,0 +1,10 @@
+DO $$
+BEGIN
+
+/*
+ * Removes the seeded pages that were never pressed.
+ */
+DELETE FROM button WHERE version = 0;
+
+END;
+$$;
@@ -0,0 +1,8 @@
+DO $$
+BEGIN
+
+ALTER TABLE button
+    DROP COLUMN IF EXISTS map_value;
+
+END;
+$$;
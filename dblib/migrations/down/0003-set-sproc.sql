@@ -0,0 +1,7 @@
+DO $$
+BEGIN
+
+DROP PROCEDURE IF EXISTS set_button_color;
+DROP FUNCTION IF EXISTS get_minimap_color;
+
+END $$;
@@ -0,0 +1,10 @@
+DO $$ 
+BEGIN
+
+DROP PROCEDURE IF EXISTS update_button_stats;
+
+DROP TABLE IF EXISTS button_stat;
+
+DROP TABLE IF EXISTS button_event;
+
+END $$;
@@ -0,0 +1,6 @@
+DO $$
+BEGIN
+
+DROP TABLE IF EXISTS sync_lock;
+
+END $$;
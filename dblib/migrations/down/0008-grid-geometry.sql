@@ -0,0 +1,42 @@
+DO $$
+BEGIN
+
+/*
+ * The schema before 0008 only knows pages of 100 buttons.
+ */
+IF (SELECT buttons_per_page FROM grid_geometry WHERE id = 1) <> 100 THEN
+    RAISE EXCEPTION 'pages of % buttons cannot be migrated below 0008', (SELECT buttons_per_page FROM grid_geometry WHERE id = 1);
+END IF;
+
+DROP PROCEDURE IF EXISTS set_page_size;
+
+/*
+ * Restores get_minimap_color from 0003-set-sproc.sql
+ */
+CREATE OR REPLACE FUNCTION get_minimap_color(bytes fixed_bytea) 
+RETURNS bytea
+AS $BODY$
+DECLARE 
+    cr INTEGER := 0;
+    cg INTEGER := 0;
+    cb INTEGER := 0;
+BEGIN
+
+FOR i IN 0..297 BY 3 LOOP
+    cr = cr + get_byte(bytes, i);
+    cg = cg + get_byte(bytes, i+1);
+    cb = cb + get_byte(bytes, i+2);
+END LOOP;
+
+    cr = cr / 100;
+    cg = cg / 100;
+    cb = cb / 100;
+
+    RETURN to_hex(cr) || to_hex(cg) || to_hex(cb);
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+
+DROP TABLE IF EXISTS grid_geometry;
+
+END $$;
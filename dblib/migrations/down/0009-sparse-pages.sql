@@ -0,0 +1,47 @@
+DO $$
+BEGIN
+
+/*
+ * Pages were seeded for every coordinate before they became sparse.
+ */
+INSERT INTO button (x_coord, y_coord)
+SELECT x.n, y.n
+FROM grid_geometry g
+CROSS JOIN LATERAL generate_series(1, g.cols) AS x(n)
+CROSS JOIN LATERAL generate_series(1, g.rows) AS y(n)
+WHERE g.id = 1
+ON CONFLICT (x_coord, y_coord) DO NOTHING;
+
+/*
+ * Restores set_button_color from 0007-button-press-versions.sql
+ */
+CREATE OR REPLACE PROCEDURE set_button_color(
+    x INTEGER,
+    y INTEGER,
+    ix INTEGER,
+    rgbVal BYTEA)
+AS $BODY$
+DECLARE
+    ixs INTEGER;
+    rV INTEGER := get_byte(rgbVal, 0);
+    gV INTEGER := get_byte(rgbVal, 1);
+    bV INTEGER := get_byte(rgbVal, 2);
+BEGIN
+
+ixs := ix * 3;
+
+UPDATE button SET 
+    buttons = set_byte(set_byte(set_byte(buttons, ixs + 2, bV), ixs + 1, gV), ixs, rV)
+    ,version = version + 1
+    ,map_value = get_minimap_color(buttons)
+    ,pressed_at = overlay(pressed_at PLACING int4send(extract(epoch FROM now())::int) FROM (ix * 4) + 1 FOR 4)
+    ,pressed_version = overlay(pressed_version PLACING int4send(version + 1) FROM (ix * 4) + 1 FOR 4)
+WHERE 
+    x_coord = x AND
+    y_coord = y AND
+    substring(buttons FROM (ixs+1) FOR 3) = '\x000000';
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
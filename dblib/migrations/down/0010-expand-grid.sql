@@ -0,0 +1,10 @@
+DO $$
+BEGIN
+
+DROP PROCEDURE IF EXISTS expand_grid;
+
+ALTER TABLE grid_geometry
+    DROP CONSTRAINT IF EXISTS grid_geometry_id_cols_check,
+    DROP COLUMN IF EXISTS id_cols;
+
+END $$;
@@ -0,0 +1,149 @@
+DO $$
+BEGIN
+
+/*
+ * Only the main canvas survives, everything else is deleted.
+ */
+DROP PROCEDURE IF EXISTS create_canvas;
+DROP PROCEDURE IF EXISTS drop_canvas;
+
+DELETE FROM button WHERE canvas <> 'main';
+DELETE FROM button_event WHERE canvas <> 'main';
+DELETE FROM button_stat WHERE canvas <> 'main';
+
+UPDATE grid_geometry g SET cols = c.cols, rows = c.rows, id_cols = c.id_cols
+FROM canvas c
+WHERE g.id = 1 AND c.name = 'main';
+
+ALTER TABLE button DROP CONSTRAINT IF EXISTS button_pkey;
+ALTER TABLE button ADD PRIMARY KEY (x_coord, y_coord);
+
+ALTER TABLE button_stat DROP CONSTRAINT IF EXISTS button_stat_pkey;
+ALTER TABLE button_stat ADD PRIMARY KEY (stat_key);
+
+DROP INDEX IF EXISTS idx_button_event_canvas_event_type;
+
+ALTER TABLE button DROP COLUMN IF EXISTS canvas;
+ALTER TABLE button_event DROP COLUMN IF EXISTS canvas;
+ALTER TABLE button_stat DROP COLUMN IF EXISTS canvas;
+
+DROP TABLE IF EXISTS canvas;
+
+DROP PROCEDURE IF EXISTS set_button_color(VARCHAR, INTEGER, INTEGER, INTEGER, BYTEA);
+
+/*
+ * Restores set_button_color from 0009-sparse-pages.sql
+ */
+CREATE OR REPLACE PROCEDURE set_button_color(
+    x INTEGER,
+    y INTEGER,
+    ix INTEGER,
+    rgbVal BYTEA)
+AS $BODY$
+DECLARE
+    ixs INTEGER;
+    rV INTEGER := get_byte(rgbVal, 0);
+    gV INTEGER := get_byte(rgbVal, 1);
+    bV INTEGER := get_byte(rgbVal, 2);
+    gridCols INTEGER;
+    gridRows INTEGER;
+BEGIN
+
+SELECT cols, rows INTO gridCols, gridRows FROM grid_geometry WHERE id = 1;
+
+IF x < 1 OR y < 1 OR x > gridCols OR y > gridRows THEN
+    RETURN;
+END IF;
+
+INSERT INTO button (x_coord, y_coord)
+VALUES (x, y)
+ON CONFLICT (x_coord, y_coord) DO NOTHING;
+
+ixs := ix * 3;
+
+UPDATE button SET 
+    buttons = set_byte(set_byte(set_byte(buttons, ixs + 2, bV), ixs + 1, gV), ixs, rV)
+    ,version = version + 1
+    ,map_value = get_minimap_color(buttons)
+    ,pressed_at = overlay(pressed_at PLACING int4send(extract(epoch FROM now())::int) FROM (ix * 4) + 1 FOR 4)
+    ,pressed_version = overlay(pressed_version PLACING int4send(version + 1) FROM (ix * 4) + 1 FOR 4)
+WHERE 
+    x_coord = x AND
+    y_coord = y AND
+    substring(buttons FROM (ixs+1) FOR 3) = '\x000000';
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS expand_grid(VARCHAR, INTEGER, INTEGER);
+
+/*
+ * Restored from 0010-expand-grid.sql
+ * Example: call expand_grid(16, 0);
+ * Appends add_cols columns and add_rows rows of pages. Pages are sparse, so
+ * this only moves the bounds recorded in grid_geometry.
+ */
+CREATE OR REPLACE PROCEDURE expand_grid(
+    add_cols INTEGER,
+    add_rows INTEGER)
+AS $BODY$
+DECLARE
+    live grid_geometry%ROWTYPE;
+BEGIN
+
+IF add_cols < 0 OR add_rows < 0 THEN
+    RAISE EXCEPTION 'grid can only grow, got % columns and % rows', add_cols, add_rows;
+END IF;
+
+SELECT * INTO live FROM grid_geometry WHERE id = 1 FOR UPDATE;
+
+IF live.cols + add_cols > live.id_cols THEN
+    RAISE EXCEPTION 'grid has button ids for % columns, cannot grow to %', live.id_cols, live.cols + add_cols;
+END IF;
+
+UPDATE grid_geometry SET
+    cols = cols + add_cols,
+    rows = rows + add_rows
+WHERE id = 1;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS update_button_stats;
+
+/*
+ * Restores update_button_stats from 0004-create-stats-table.sql
+ */
+CREATE OR REPLACE PROCEDURE update_button_stats()
+AS $BODY$
+BEGIN
+
+UPDATE button_stat SET val = (
+    SELECT COUNT(*) 
+    FROM button_event
+    WHERE event_type = 'press'
+) 
+WHERE stat_key = 'buttons_pressed';
+
+
+UPDATE button_stat SET val = (
+    SELECT COUNT(*) 
+    FROM button_event
+    WHERE event_type = 'press'
+    AND created_at >= NOW() - INTERVAL '1 day'
+) 
+WHERE stat_key = 'pressed_last_day';
+
+
+UPDATE button_stat SET val = (
+    SELECT COUNT(*) * 1000 / 600
+    FROM button_event
+    WHERE event_type = 'press'
+    AND created_at >= NOW() - INTERVAL '10 minutes'
+) 
+WHERE stat_key = 'presses_per_second';
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
@@ -0,0 +1,103 @@
+DO $$
+BEGIN
+
+/*
+ * Archived seasons are kept as ordinary, writable canvases.
+ */
+DROP PROCEDURE IF EXISTS start_season;
+
+ALTER TABLE canvas
+    DROP COLUMN IF EXISTS season,
+    DROP COLUMN IF EXISTS read_only,
+    DROP COLUMN IF EXISTS archived_from,
+    DROP COLUMN IF EXISTS archived_at;
+
+DROP PROCEDURE IF EXISTS set_button_color(VARCHAR, INTEGER, INTEGER, INTEGER, BYTEA);
+
+/*
+ * Restores set_button_color from 0011-canvases.sql
+ */
+CREATE OR REPLACE PROCEDURE set_button_color(
+    canvasName VARCHAR,
+    x INTEGER,
+    y INTEGER,
+    ix INTEGER,
+    rgbVal BYTEA)
+AS $BODY$
+DECLARE
+    ixs INTEGER;
+    rV INTEGER := get_byte(rgbVal, 0);
+    gV INTEGER := get_byte(rgbVal, 1);
+    bV INTEGER := get_byte(rgbVal, 2);
+    gridCols INTEGER;
+    gridRows INTEGER;
+BEGIN
+
+SELECT cols, rows INTO gridCols, gridRows FROM canvas WHERE name = canvasName;
+
+IF gridCols IS NULL OR x < 1 OR y < 1 OR x > gridCols OR y > gridRows THEN
+    RETURN;
+END IF;
+
+INSERT INTO button (canvas, x_coord, y_coord)
+VALUES (canvasName, x, y)
+ON CONFLICT (canvas, x_coord, y_coord) DO NOTHING;
+
+ixs := ix * 3;
+
+UPDATE button SET 
+    buttons = set_byte(set_byte(set_byte(buttons, ixs + 2, bV), ixs + 1, gV), ixs, rV)
+    ,version = version + 1
+    ,map_value = get_minimap_color(buttons)
+    ,pressed_at = overlay(pressed_at PLACING int4send(extract(epoch FROM now())::int) FROM (ix * 4) + 1 FOR 4)
+    ,pressed_version = overlay(pressed_version PLACING int4send(version + 1) FROM (ix * 4) + 1 FOR 4)
+WHERE 
+    canvas = canvasName AND
+    x_coord = x AND
+    y_coord = y AND
+    substring(buttons FROM (ixs+1) FOR 3) = '\x000000';
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS update_button_stats;
+
+/*
+ * Restores update_button_stats from 0011-canvases.sql
+ */
+CREATE OR REPLACE PROCEDURE update_button_stats()
+AS $BODY$
+BEGIN
+
+UPDATE button_stat s SET val = (
+    SELECT COUNT(*) 
+    FROM button_event e
+    WHERE e.event_type = 'press'
+    AND e.canvas = s.canvas
+) 
+WHERE stat_key = 'buttons_pressed';
+
+
+UPDATE button_stat s SET val = (
+    SELECT COUNT(*) 
+    FROM button_event e
+    WHERE e.event_type = 'press'
+    AND e.canvas = s.canvas
+    AND e.created_at >= NOW() - INTERVAL '1 day'
+) 
+WHERE stat_key = 'pressed_last_day';
+
+
+UPDATE button_stat s SET val = (
+    SELECT COUNT(*) * 1000 / 600
+    FROM button_event e
+    WHERE e.event_type = 'press'
+    AND e.canvas = s.canvas
+    AND e.created_at >= NOW() - INTERVAL '10 minutes'
+) 
+WHERE stat_key = 'presses_per_second';
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
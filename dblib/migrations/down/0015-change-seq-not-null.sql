@@ -0,0 +1,8 @@
+DO $$
+BEGIN
+
+ALTER TABLE button ALTER COLUMN change_seq DROP NOT NULL;
+
+ALTER TABLE button DROP CONSTRAINT IF EXISTS button_change_seq_not_null;
+
+END $$;
@@ -0,0 +1,74 @@
+DO $$
+BEGIN
+
+DROP PROCEDURE IF EXISTS create_canvas(VARCHAR, INTEGER, INTEGER, INTEGER);
+
+/*
+ * Restores create_canvas from 0011-canvases.sql
+ *
+ * Example: call create_canvas('event', 64, 64, 256);
+ */
+CREATE OR REPLACE PROCEDURE create_canvas(
+    canvasName VARCHAR,
+    gridCols INTEGER,
+    gridRows INTEGER,
+    idCols INTEGER DEFAULT NULL)
+AS $BODY$
+BEGIN
+
+INSERT INTO canvas (name, cols, rows, id_cols)
+VALUES (canvasName, gridCols, gridRows, COALESCE(idCols, gridCols));
+
+INSERT INTO button_stat (canvas, stat_key, stat_name, stat_desc, val, "scale", "order")
+SELECT canvasName, stat_key, stat_name, stat_desc, 0, "scale", "order"
+FROM button_stat
+WHERE canvas = 'main'
+ON CONFLICT DO NOTHING;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+DROP PROCEDURE IF EXISTS start_season;
+
+/*
+ * Restores start_season from 0012-seasons.sql
+ *
+ * Example: call start_season('main');
+ */
+CREATE OR REPLACE PROCEDURE start_season(canvasName VARCHAR)
+AS $BODY$
+DECLARE
+    live canvas%ROWTYPE;
+    archiveName VARCHAR;
+BEGIN
+
+SELECT * INTO live FROM canvas WHERE name = canvasName FOR UPDATE;
+
+IF NOT FOUND THEN
+    RAISE EXCEPTION 'canvas % does not exist', canvasName;
+END IF;
+
+IF live.read_only THEN
+    RAISE EXCEPTION 'canvas % is an archive', canvasName;
+END IF;
+
+archiveName := canvasName || '-s' || live.season;
+
+INSERT INTO canvas (name, cols, rows, id_cols, season, read_only, archived_from, archived_at)
+VALUES (archiveName, live.cols, live.rows, live.id_cols, live.season, true, canvasName, CURRENT_TIMESTAMP);
+
+UPDATE button SET canvas = archiveName WHERE canvas = canvasName;
+UPDATE button_event SET canvas = archiveName WHERE canvas = canvasName;
+
+INSERT INTO button_stat (canvas, stat_key, stat_name, stat_desc, val, "scale", "order")
+SELECT archiveName, stat_key, stat_name, stat_desc, val, "scale", "order"
+FROM button_stat
+WHERE canvas = canvasName;
+
+UPDATE button_stat SET val = 0 WHERE canvas = canvasName;
+UPDATE canvas SET season = season + 1 WHERE name = canvasName;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
@@ -0,0 +1,56 @@
+DO $$
+BEGIN
+
+DROP PROCEDURE IF EXISTS start_season;
+
+/*
+ * Restores start_season from 0017-season-archive-names.sql
+ *
+ * Example: call start_season('main');
+ * The archive keeps the id stride, and so the button ids, of the canvas.
+ */
+CREATE OR REPLACE PROCEDURE start_season(canvasName VARCHAR)
+AS $BODY$
+DECLARE
+    live canvas%ROWTYPE;
+    archiveName VARCHAR;
+BEGIN
+
+SELECT * INTO live FROM canvas WHERE name = canvasName FOR UPDATE;
+
+IF NOT FOUND THEN
+    RAISE EXCEPTION 'canvas % does not exist', canvasName;
+END IF;
+
+IF live.read_only THEN
+    RAISE EXCEPTION 'canvas % is an archive', canvasName;
+END IF;
+
+archiveName := canvasName || '-s' || live.season;
+
+IF length(archiveName) > 20 THEN
+    RAISE EXCEPTION 'season archive name % is longer than 20 characters', archiveName;
+END IF;
+
+IF EXISTS (SELECT 1 FROM canvas WHERE name = archiveName) THEN
+    RAISE EXCEPTION 'season archive name % is already taken by another canvas', archiveName;
+END IF;
+
+INSERT INTO canvas (name, cols, rows, id_cols, season, read_only, archived_from, archived_at)
+VALUES (archiveName, live.cols, live.rows, live.id_cols, live.season, true, canvasName, CURRENT_TIMESTAMP);
+
+UPDATE button SET canvas = archiveName WHERE canvas = canvasName;
+UPDATE button_event SET canvas = archiveName WHERE canvas = canvasName;
+
+INSERT INTO button_stat (canvas, stat_key, stat_name, stat_desc, val, "scale", "order")
+SELECT archiveName, stat_key, stat_name, stat_desc, val, "scale", "order"
+FROM button_stat
+WHERE canvas = canvasName;
+
+UPDATE button_stat SET val = 0 WHERE canvas = canvasName;
+UPDATE canvas SET season = season + 1 WHERE name = canvasName;
+
+END;
+$BODY$ LANGUAGE PLPGSQL;
+
+END $$;
@@ -0,0 +1,14 @@
+#!/bin/bash
+# Functions only upload this directory, so the parts of the repository module
+# the function builds against are copied into it before every deploy.
+set -euo pipefail
+
+cd "$(dirname "$0")"
+
+repo=../../../..
+
+rm -rf obb
+mkdir obb
+cp "$repo/go.mod" "$repo/go.sum" obb/
+cp -r "$repo/dblib" "$repo/minimaplib" obb/
+find obb -name '*_test.go' -delete
@@ -1,10 +1,15 @@
 module github.com/cmcquillan/one-billion-buttons/minimap
 
-go 1.23
+go 1.24.3
 
-require github.com/cmcquillan/one-billion-buttons v0.6.0
+require (
+	github.com/cmcquillan/one-billion-buttons v0.0.0-00010101000000-000000000000
+	github.com/kelseyhightower/envconfig v1.4.0
+)
 
 require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/lib/pq v1.10.9 // indirect
 )
+
+replace github.com/cmcquillan/one-billion-buttons => ./obb
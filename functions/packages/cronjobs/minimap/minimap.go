@@ -2,105 +2,214 @@ package main
 
 import (
 	"context"
-	"image"
-	"image/color"
-	"image/png"
+	"fmt"
 	"log"
-	"os"
 	"time"
 
 	"github.com/cmcquillan/one-billion-buttons/dblib"
+	"github.com/cmcquillan/one-billion-buttons/minimaplib"
+	"github.com/kelseyhightower/envconfig"
 )
 
+// FunctionConfig is read from the environment set in project.yml. It shares
+// the store and render settings with the app.
+type FunctionConfig struct {
+	PgConnectionString  string        `envconfig:"PG_CONNECTION_STRING" required:"true"`
+	MinimapLockTimeout  time.Duration `envconfig:"MINIMAP_LOCK_TIMEOUT" default:"10m"`
+	MinimapIdleInterval time.Duration `envconfig:"MINIMAP_IDLE_INTERVAL" default:"10m"`
+
+	minimaplib.StoreConfig
+	minimaplib.RenderConfig
+	minimaplib.ArchiveConfig
+}
+
+// CanvasStatus is what happened to the minimap of one canvas.
+type CanvasStatus struct {
+	Canvas     string `json:"canvas"`
+	Status     string `json:"status"`
+	DurationMs int64  `json:"duration_ms"`
+	Rows       int64  `json:"rows"`
+	Error      string `json:"error,omitempty"`
+}
+
+const (
+	STATUS_DRAWN  = "drawn"
+	STATUS_FRESH  = "fresh"
+	STATUS_LOCKED = "locked"
+	STATUS_ERROR  = "error"
+	STATUS_OK     = "ok"
+)
+
+// FUNCTION_LIMIT is the longest a function run is allowed to take.
+const FUNCTION_LIMIT = 10 * time.Minute
+
+// Main draws the minimap of every canvas, or of args["canvas"]. Minimaps
+// drawn within MINIMAP_IDLE_INTERVAL are left alone unless args["force"] is
+// set.
 func Main(args map[string]interface{}) map[string]interface{} {
+	start := time.Now()
 
-	status := "unknown"
+	var cfg FunctionConfig
 
-	connStr := os.Getenv("PG_CONNETION_STRING")
+	if err := envconfig.Process("", &cfg); err != nil {
+		return failed(start, fmt.Errorf("could not load config: %w", err))
+	}
 
-	locker := &dblib.LockSql{
-		ConnStr: connStr,
+	store, err := minimaplib.NewStore(&cfg.StoreConfig)
+
+	if err != nil {
+		return failed(start, fmt.Errorf("could not create minimap store: %w", err))
 	}
 
-	db := &ObbDbSql{
-		connStr: connStr,
+	db := &minimaplib.MinimapDbSql{
+		ConnStr: cfg.PgConnectionString,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*10)
 
+	locker := &dblib.LockSql{
+		ConnStr: cfg.PgConnectionString,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), FUNCTION_LIMIT)
 	defer cancel()
 
-	CreateMinimap(locker, db, ctx)
+	canvases, err := db.ReadCanvases(ctx)
 
-	msg := make(map[string]interface{})
-	msg["status"] = status
-	return msg
-}
+	if err != nil {
+		return failed(start, fmt.Errorf("could not read canvases: %w", err))
+	}
 
-const MINIMAP_LOCK_TYPE = "minimap_gen"
+	only, _ := args["canvas"].(string)
+	force := isSet(args["force"])
+	statuses := []CanvasStatus{}
+	status, rows, errMsg := STATUS_OK, int64(0), ""
 
-func CreateMinimap(locker dblib.Lock, db ObbDb, ctx context.Context) {
-	log.Print("Background minimap maker started")
+	for _, canvas := range canvases {
+		if len(only) > 0 && canvas.Name != only {
+			continue
+		}
 
-	lockVal, err := locker.AcquireLock(MINIMAP_LOCK_TYPE, time.Hour)
+		s := CreateMinimap(locker, db, store, canvas, force, ctx, &cfg)
+		statuses = append(statuses, s)
+		rows += s.Rows
 
-	if err == dblib.ErrLockNotAcquired {
-		log.Printf("%s lock already acquired, deferring work", MINIMAP_LOCK_TYPE)
-		return
+		if s.Status == STATUS_ERROR {
+			status, errMsg = STATUS_ERROR, s.Error
+		}
 	}
 
-	if err != nil {
-		log.Printf("error acquiring lock: %v", err)
-		return
+	if len(only) > 0 && len(statuses) == 0 {
+		return failed(start, fmt.Errorf("unknown canvas %q", only))
 	}
 
-	log.Printf("lock %s acquired for %s", lockVal.Value, lockVal.Type)
+	return map[string]interface{}{
+		"status":      status,
+		"duration_ms": time.Since(start).Milliseconds(),
+		"rows":        rows,
+		"error":       errMsg,
+		"canvases":    statuses,
+	}
+}
 
-	mmChan := make(chan *MinimapItem, 10000)
+func failed(start time.Time, err error) map[string]interface{} {
+	log.Print(err)
 
-	x, y, err := db.GetImageDimensions()
+	return map[string]interface{}{
+		"status":      STATUS_ERROR,
+		"duration_ms": time.Since(start).Milliseconds(),
+		"rows":        0,
+		"error":       err.Error(),
+	}
+}
 
-	if err != nil {
-		log.Printf("unable to get correct dimensions for map: %v", err)
+// isSet reads a flag passed as a JSON boolean or as a query parameter.
+func isSet(arg interface{}) bool {
+	switch v := arg.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true" || v == "1"
 	}
 
-	go db.BeginMinimapStreaming(mmChan, ctx)
+	return false
+}
 
-	minimap := image.NewRGBA64(image.Rect(0, 0, int(x), int(y)))
+// CreateMinimap draws every page of a canvas and publishes the minimap in
+// every configured format, under the same lock as the app.
+func CreateMinimap(locker dblib.Lock, db minimaplib.PageDb, store minimaplib.MinimapStore, canvas minimaplib.CanvasSize, force bool, ctx context.Context, cfg *FunctionConfig) CanvasStatus {
+	start := time.Now()
+	status := CanvasStatus{Canvas: canvas.Name}
 
-	for mmItem := range mmChan {
-		alpha := 0
+	finish := func(result string, err error) CanvasStatus {
+		status.Status = result
+		status.DurationMs = time.Since(start).Milliseconds()
 
-		if mmItem.RGB[0]+mmItem.RGB[1]+mmItem.RGB[2] > 0 {
-			alpha = 255
+		if err != nil {
+			log.Printf("could not draw %s minimap: %v", canvas.Name, err)
+			status.Error = err.Error()
 		}
 
-		c := color.RGBA{
-			R: uint8(mmItem.RGB[0]),
-			G: uint8(mmItem.RGB[1]),
-			B: uint8(mmItem.RGB[2]),
-			A: uint8(alpha),
+		return status
+	}
+
+	if !force {
+		info, err := minimaplib.ReadMinimapInfo(store, canvas.Name, ctx)
+
+		if err != nil && err != minimaplib.ErrNotFound {
+			return finish(STATUS_ERROR, err)
 		}
 
-		minimap.Set(int(mmItem.X), int(mmItem.Y), c)
+		// Archived canvases never change once their final minimap is drawn
+		if info != nil && (canvas.ReadOnly || time.Since(info.GeneratedAt) < cfg.MinimapIdleInterval) {
+			return finish(STATUS_FRESH, nil)
+		}
 	}
 
-	e := png.Encoder{
-		CompressionLevel: png.BestCompression,
+	lockType := minimaplib.MinimapLockType(canvas.Name)
+	lockVal, err := locker.AcquireLock(lockType, cfg.MinimapLockTimeout)
+
+	if err == dblib.ErrLockNotAcquired {
+		log.Printf("%s lock already acquired, deferring work", lockType)
+		return finish(STATUS_LOCKED, nil)
 	}
 
-	file, err := os.OpenFile("./static/minimap.png", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
-		log.Printf("failed to open minimap file: %v", err)
-		return
+		return finish(STATUS_ERROR, fmt.Errorf("error acquiring lock: %w", err))
 	}
 
-	err = e.Encode(file, minimap)
+	defer locker.ReleaseLock(lockVal)
+
+	log.Printf("lock %s acquired for %s", lockVal.Value, lockVal.Type)
+
+	m, scanned, err := minimaplib.DrawFullMinimap(db, canvas.Name, canvas.Cols, canvas.Rows, ctx, &cfg.RenderConfig)
+	status.Rows = scanned
 
 	if err != nil {
-		log.Printf("could not encode png minimap: %v", err)
+		return finish(STATUS_ERROR, err)
 	}
 
-	defer log.Printf("lock %s released for %s", lockVal.Value, lockVal.Type)
-	defer locker.ReleaseLock(lockVal)
-	log.Print("Background minimap maker stopped")
+	variants, err := minimaplib.EncodeMinimap(m.Image, cfg.DrawnFormats())
+
+	if err != nil {
+		return finish(STATUS_ERROR, err)
+	}
+
+	info := minimaplib.MinimapInfo{
+		GeneratedAt:  time.Now(),
+		DurationMs:   time.Since(start).Milliseconds(),
+		Width:        canvas.Cols,
+		Height:       canvas.Rows,
+		PagesScanned: scanned,
+		Full:         true,
+	}
+
+	if _, err := minimaplib.Publish(store, canvas.Name, variants, info, ctx); err != nil {
+		return finish(STATUS_ERROR, err)
+	}
+
+	// A missed snapshot is taken by a later run
+	if _, err := minimaplib.ArchiveMinimap(store, canvas.Name, variants[minimaplib.MINIMAP_PNG], info, ctx, &cfg.ArchiveConfig); err != nil {
+		log.Printf("could not archive %s minimap: %v", canvas.Name, err)
+	}
+
+	return finish(STATUS_DRAWN, nil)
 }
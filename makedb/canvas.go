@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+)
+
+// DEFAULT_CANVAS is the canvas commands act on when none is named. It always
+// exists and cannot be dropped.
+const DEFAULT_CANVAS = "main"
+
+// CreateCanvas adds a named canvas with its own bounds. The page size is
+// shared by every canvas and comes from grid_geometry. The optional id-cols
+// reserves button ids for that many columns, which is as wide as the canvas
+// can later be expanded.
+func CreateCanvas(dbc *sql.DB, args []string) error {
+	if len(args) != 3 && len(args) != 4 {
+		return errors.New("usage: create-canvas <name> <cols> <rows> [id-cols]")
+	}
+
+	cols, errCols := strconv.ParseInt(args[1], 10, 64)
+	rows, errRows := strconv.ParseInt(args[2], 10, 64)
+
+	if errCols != nil || errRows != nil || cols <= 0 || rows <= 0 {
+		return errors.New("create-canvas requires positive column and row counts")
+	}
+
+	idCols := cols
+
+	if len(args) == 4 {
+		var err error
+
+		if idCols, err = strconv.ParseInt(args[3], 10, 64); err != nil || idCols < cols {
+			return errors.New("create-canvas requires id-cols of at least the column count")
+		}
+	}
+
+	if _, err := dbc.Exec("call create_canvas($1, $2, $3, $4)", args[0], cols, rows, idCols); err != nil {
+		return err
+	}
+
+	log.Printf("created canvas %s with %dx%d pages", args[0], cols, rows)
+	return nil
+}
+
+// DropCanvas removes a canvas along with all of its pages, events and stats.
+func DropCanvas(dbc *sql.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: drop-canvas <name>")
+	}
+
+	if _, err := dbc.Exec("call drop_canvas($1)", args[0]); err != nil {
+		return err
+	}
+
+	log.Printf("dropped canvas %s", args[0])
+	return nil
+}
+
+func ReadCanvasBounds(dbc *sql.DB, canvas string) (cols int64, rows int64, err error) {
+	row := dbc.QueryRow("SELECT cols, rows FROM canvas WHERE name = $1", canvas)
+	err = row.Scan(&cols, &rows)
+
+	return cols, rows, err
+}
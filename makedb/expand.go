@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/cmcquillan/one-billion-buttons/dblib"
+)
+
+// ExpandGrid grows a canvas by the column and row counts in args while the
+// app keeps serving. The app picks up the new geometry on its next refresh.
+func ExpandGrid(dbc *sql.DB, connStr string, args []string) error {
+	if len(args) != 2 && len(args) != 3 {
+		return errors.New("usage: expand <cols> <rows> [canvas]")
+	}
+
+	canvas := DEFAULT_CANVAS
+
+	if len(args) == 3 {
+		canvas = args[2]
+	}
+
+	addCols, errCols := strconv.ParseInt(args[0], 10, 64)
+	addRows, errRows := strconv.ParseInt(args[1], 10, 64)
+
+	if errCols != nil || errRows != nil || addCols < 0 || addRows < 0 || addCols+addRows == 0 {
+		return errors.New("expand requires non-negative column and row counts, at least one positive")
+	}
+
+	locker := &dblib.LockSql{ConnStr: connStr}
+	lockVal, err := locker.AcquireLock(dblib.GRID_EXPAND_LOCK_TYPE, time.Hour)
+
+	if err != nil {
+		return err
+	}
+
+	defer locker.ReleaseLock(lockVal)
+
+	cols, rows, err := ReadCanvasBounds(dbc, canvas)
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("expanding canvas %s from %dx%d by %d columns and %d rows", canvas, cols, rows, addCols, addRows)
+
+	start := time.Now()
+
+	if _, err := dbc.Exec("call expand_grid($1, $2, $3)", canvas, addCols, addRows); err != nil {
+		return err
+	}
+
+	cols, rows, err = ReadCanvasBounds(dbc, canvas)
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("canvas %s is now %dx%d pages... %v", canvas, cols, rows, -time.Until(start))
+	return nil
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Problems beyond this many are counted but not listed.
+const FSCK_REPORT_LIMIT = 20
+
+type FsckOptions struct {
+	Repair  bool
+	LockAge time.Duration
+	Canvas  string
+}
+
+func parseFsckOptions(args []string) (*FsckOptions, error) {
+	opts := FsckOptions{LockAge: 2 * time.Hour}
+
+	for _, arg := range args {
+		switch {
+		case arg == "--repair":
+			opts.Repair = true
+		case strings.HasPrefix(arg, "--lock-age="):
+			age, err := time.ParseDuration(strings.TrimPrefix(arg, "--lock-age="))
+
+			if err != nil || age <= 0 {
+				return nil, errors.New("--lock-age must be a positive duration")
+			}
+
+			opts.LockAge = age
+		case len(opts.Canvas) == 0 && !strings.HasPrefix(arg, "-"):
+			opts.Canvas = arg
+		default:
+			return nil, errors.New("usage: fsck [--repair] [--lock-age=2h] [canvas]")
+		}
+	}
+
+	return &opts, nil
+}
+
+// Fsck checks the grid for state that has drifted: stale minimap colors,
+// page versions that disagree with the press events, stats that disagree
+// with the press events and locks that were never released. --repair fixes
+// what it can, see fsckPageVersions for the versions it cannot rebuild.
+func Fsck(dbc *sql.DB, args []string) error {
+	opts, err := parseFsckOptions(args)
+
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+
+	checks := []func(*sql.DB, *FsckOptions) (int, error){
+		fsckMapValues,
+		fsckPageVersions,
+		fsckStats,
+		fsckLocks,
+	}
+
+	for _, check := range checks {
+		n, err := check(dbc, opts)
+
+		if err != nil {
+			return err
+		}
+
+		problems += n
+	}
+
+	if problems > 0 && !opts.Repair {
+		return fmt.Errorf("found %d problems, rerun with --repair to fix them", problems)
+	}
+
+	log.Printf("fsck finished with %d problems", problems)
+	return nil
+}
+
+// canvasFilter restricts a query to the canvas given on the command line.
+func canvasFilter(opts *FsckOptions, column string) (string, []any) {
+	if len(opts.Canvas) == 0 {
+		return "", nil
+	}
+
+	return fmt.Sprintf(" AND %s = $1", column), []any{opts.Canvas}
+}
+
+func fsckMapValues(dbc *sql.DB, opts *FsckOptions) (int, error) {
+	filter, args := canvasFilter(opts, "canvas")
+
+	rows, err := dbc.Query(`
+		SELECT canvas, x_coord, y_coord
+		FROM button
+		WHERE map_value IS DISTINCT FROM get_minimap_color(buttons)`+filter+`
+		ORDER BY canvas, y_coord, x_coord`, args...)
+
+	if err != nil {
+		return 0, err
+	}
+
+	stale := 0
+
+	for rows.Next() {
+		var canvas string
+		var x, y int64
+
+		if err := rows.Scan(&canvas, &x, &y); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		if stale < FSCK_REPORT_LIMIT {
+			log.Printf("stale map_value on canvas %s page %d,%d", canvas, x, y)
+		}
+
+		stale++
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("%d pages have a stale map_value", stale)
+
+	if stale > 0 && opts.Repair {
+		res, err := dbc.Exec(`
+			UPDATE button SET map_value = get_minimap_color(buttons)
+			WHERE map_value IS DISTINCT FROM get_minimap_color(buttons)`+filter, args...)
+
+		if err != nil {
+			return stale, err
+		}
+
+		fixed, _ := res.RowsAffected()
+		log.Printf("recomputed map_value of %d pages", fixed)
+	}
+
+	return stale, nil
+}
+
+// fsckPageVersions compares every page version with its press events. Each
+// press bumps the version once and is logged once, but events are written in
+// batches and can be lost, so a version ahead of its events is expected after
+// a crash. Pages without a row but with events are reported too.
+//
+// --repair rebuilds version and pressed_version of every page that has press
+// events: the version is the number of presses and each button records the
+// position of its last press among them. Pages without events, such as
+// imported ones, and events without a page have nothing to rebuild from. A
+// client polling with ?since above a rewound version sees no changes to the
+// page until it reloads.
+func fsckPageVersions(dbc *sql.DB, opts *FsckOptions) (int, error) {
+	filter, args := canvasFilter(opts, "coalesce(b.canvas, e.canvas)")
+
+	rows, err := dbc.Query(`
+		SELECT coalesce(b.canvas, e.canvas), coalesce(b.x_coord, e.x_coord), coalesce(b.y_coord, e.y_coord),
+			coalesce(b.version, 0), coalesce(e.presses, 0)
+		FROM button b
+		FULL JOIN (
+			SELECT canvas, x_coord, y_coord, COUNT(*) AS presses
+			FROM button_event
+			WHERE event_type = 'press'
+			GROUP BY canvas, x_coord, y_coord
+		) e ON e.canvas = b.canvas AND e.x_coord = b.x_coord AND e.y_coord = b.y_coord
+		WHERE coalesce(b.version, 0) <> coalesce(e.presses, 0)`+filter+`
+		ORDER BY 1, 3, 2`, args...)
+
+	if err != nil {
+		return 0, err
+	}
+
+	mismatched := 0
+
+	for rows.Next() {
+		var canvas string
+		var x, y, version, presses int64
+
+		if err := rows.Scan(&canvas, &x, &y, &version, &presses); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		if mismatched < FSCK_REPORT_LIMIT {
+			log.Printf("canvas %s page %d,%d has version %d but %d press events", canvas, x, y, version, presses)
+		}
+
+		mismatched++
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	log.Printf("%d pages have a version that disagrees with their events", mismatched)
+
+	if mismatched > 0 && opts.Repair {
+		filter, args := canvasFilter(opts, "e.canvas")
+
+		res, err := dbc.Exec(`
+			WITH press AS (
+				SELECT e.canvas, e.x_coord, e.y_coord, e.button_id % g.buttons_per_page AS ix,
+					row_number() OVER (PARTITION BY e.canvas, e.x_coord, e.y_coord ORDER BY e.created_at, e.id) AS v
+				FROM button_event e
+				CROSS JOIN grid_geometry g
+				WHERE g.id = 1 AND e.event_type = 'press'`+filter+`
+			), last_press AS (
+				SELECT canvas, x_coord, y_coord, ix, max(v) AS v
+				FROM press
+				GROUP BY canvas, x_coord, y_coord, ix
+			), rebuilt AS (
+				SELECT p.canvas, p.x_coord, p.y_coord, p.version,
+					string_agg(int4send(coalesce(l.v, 0)::int), ''::bytea ORDER BY n.ix) AS pressed_version
+				FROM (
+					SELECT canvas, x_coord, y_coord, max(v) AS version
+					FROM press
+					GROUP BY canvas, x_coord, y_coord
+				) p
+				CROSS JOIN grid_geometry g
+				CROSS JOIN LATERAL generate_series(0, g.buttons_per_page - 1) AS n(ix)
+				LEFT JOIN last_press l ON l.canvas = p.canvas AND l.x_coord = p.x_coord AND l.y_coord = p.y_coord AND l.ix = n.ix
+				WHERE g.id = 1
+				GROUP BY p.canvas, p.x_coord, p.y_coord, p.version
+			)
+			UPDATE button b SET
+				version = r.version,
+				pressed_version = r.pressed_version,
+				change_seq = nextval('button_change_seq')
+			FROM rebuilt r
+			WHERE b.canvas = r.canvas AND b.x_coord = r.x_coord AND b.y_coord = r.y_coord
+			AND b.version <> r.version`, args...)
+
+		if err != nil {
+			return mismatched, err
+		}
+
+		fixed, _ := res.RowsAffected()
+		log.Printf("rebuilt the versions of %d pages from their events, %d pages have nothing to rebuild from", fixed, int64(mismatched)-fixed)
+	}
+
+	return mismatched, nil
+}
+
+func fsckStats(dbc *sql.DB, opts *FsckOptions) (int, error) {
+	filter, args := canvasFilter(opts, "s.canvas")
+
+	rows, err := dbc.Query(`
+		SELECT s.canvas, s.val, (
+			SELECT COUNT(*)
+			FROM button_event e
+			WHERE e.event_type = 'press'
+			AND e.canvas = s.canvas
+		)
+		FROM button_stat s
+		WHERE s.stat_key = 'buttons_pressed'`+filter+`
+		ORDER BY s.canvas`, args...)
+
+	if err != nil {
+		return 0, err
+	}
+
+	type drift struct {
+		canvas  string
+		val     int64
+		presses int64
+	}
+
+	drifted := make([]drift, 0)
+
+	for rows.Next() {
+		d := drift{}
+
+		if err := rows.Scan(&d.canvas, &d.val, &d.presses); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		if d.val != d.presses {
+			drifted = append(drifted, d)
+		}
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, d := range drifted {
+		log.Printf("canvas %s counts %d buttons pressed but has %d press events", d.canvas, d.val, d.presses)
+
+		if !opts.Repair {
+			continue
+		}
+
+		// Set directly so archived canvases, which update_button_stats skips, are fixed too
+		_, err := dbc.Exec(
+			"UPDATE button_stat SET val = $1 WHERE canvas = $2 AND stat_key = 'buttons_pressed'",
+			d.presses, d.canvas)
+
+		if err != nil {
+			return len(drifted), err
+		}
+	}
+
+	if len(drifted) > 0 && opts.Repair {
+		if _, err := dbc.Exec("call update_button_stats()"); err != nil {
+			return len(drifted), err
+		}
+
+		log.Printf("recomputed stats of %d canvases", len(drifted))
+	}
+
+	return len(drifted), nil
+}
+
+func fsckLocks(dbc *sql.DB, opts *FsckOptions) (int, error) {
+	rows, err := dbc.Query(`
+		SELECT id, lock_val, lock_time
+		FROM sync_lock
+		WHERE lock_val IS NOT NULL
+		AND lock_time < NOW() - make_interval(secs => $1)
+		ORDER BY id`, opts.LockAge.Seconds())
+
+	if err != nil {
+		return 0, err
+	}
+
+	type stuckLock struct {
+		id   string
+		val  string
+		time time.Time
+	}
+
+	stuck := make([]stuckLock, 0)
+
+	for rows.Next() {
+		l := stuckLock{}
+
+		if err := rows.Scan(&l.id, &l.val, &l.time); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		stuck = append(stuck, l)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, l := range stuck {
+		log.Printf("lock %s has been held by %s since %v", l.id, l.val, l.time)
+
+		if !opts.Repair {
+			continue
+		}
+
+		_, err := dbc.Exec(
+			"UPDATE sync_lock SET lock_val = NULL, lock_time = NULL WHERE id = $1 AND lock_val = $2",
+			l.id, l.val)
+
+		if err != nil {
+			return len(stuck), err
+		}
+
+		log.Printf("released lock %s", l.id)
+	}
+
+	return len(stuck), nil
+}
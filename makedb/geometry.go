@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// GridGeometry is the page size in grid_geometry and the bounds of the main
+// canvas, as set by makedb create.
+type GridGeometry struct {
+	Cols           int64
+	Rows           int64
+	ButtonsPerPage int64
+}
+
+var defaultGeometry = GridGeometry{Cols: 4096, Rows: 2500, ButtonsPerPage: 100}
+
+// GeometryFromEnv reads GRID_COLS, GRID_ROWS and GRID_BUTTONS_PER_PAGE. It
+// returns nil when none of them are set so an existing geometry is kept.
+func GeometryFromEnv() (*GridGeometry, error) {
+	names := []string{"GRID_COLS", "GRID_ROWS", "GRID_BUTTONS_PER_PAGE"}
+	defaults := []int64{defaultGeometry.Cols, defaultGeometry.Rows, defaultGeometry.ButtonsPerPage}
+	vals := make([]int64, len(names))
+	found := false
+
+	for i, name := range names {
+		raw := os.Getenv(name)
+
+		if len(raw) == 0 {
+			vals[i] = defaults[i]
+			continue
+		}
+
+		v, err := strconv.ParseInt(raw, 10, 64)
+
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("%s must be a positive integer", name)
+		}
+
+		vals[i] = v
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+
+	return &GridGeometry{Cols: vals[0], Rows: vals[1], ButtonsPerPage: vals[2]}, nil
+}
+
+// WriteGeometry sizes a freshly migrated grid. The page size belongs to the
+// schema and is changed by set_page_size, the bounds are those of the main
+// canvas. A grid that already has pages keeps its geometry.
+func WriteGeometry(dbc *sql.DB, g *GridGeometry) error {
+	current, err := ReadGeometry(dbc)
+
+	if err != nil {
+		return err
+	}
+
+	if *current != *g {
+		tx, err := dbc.Begin()
+
+		if err != nil {
+			return err
+		}
+
+		defer tx.Rollback()
+
+		var hasPages bool
+
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM button)").Scan(&hasPages); err != nil {
+			return err
+		}
+
+		if hasPages {
+			return errors.New("database already has a different grid geometry")
+		}
+
+		if _, err := tx.Exec("CALL set_page_size($1)", g.ButtonsPerPage); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE canvas SET cols = $1, rows = $2, id_cols = $1 WHERE name = $3", g.Cols, g.Rows, DEFAULT_CANVAS)
+
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("grid geometry is %dx%d pages of %d buttons", g.Cols, g.Rows, g.ButtonsPerPage)
+	return nil
+}
+
+// ReadGeometry reads the page size and the bounds of the main canvas.
+func ReadGeometry(dbc *sql.DB) (*GridGeometry, error) {
+	g := GridGeometry{}
+
+	row := dbc.QueryRow(`
+		SELECT c.cols, c.rows, g.buttons_per_page
+		FROM canvas c
+		CROSS JOIN grid_geometry g
+		WHERE g.id = 1 AND c.name = $1`, DEFAULT_CANVAS)
+
+	err := row.Scan(&g.Cols, &g.Rows, &g.ButtonsPerPage)
+
+	return &g, err
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/cmcquillan/one-billion-buttons/dblib"
 	_ "github.com/lib/pq"
 )
 
@@ -48,15 +49,86 @@ func main() {
 
 		switch verb {
 		case "create":
-			if errCreate := ExecDir(dbc, "./migrations"); errCreate != nil {
+			opts := ParseMigrateOptions(args[1:])
+
+			if errCreate := MigrateUp(dbc, connStr, opts); errCreate != nil {
 				log.Printf("failed to execute db creation: %v", errCreate)
 				failure = true
+				break
+			}
+
+			if !opts.DryRun {
+				grid, errGrid := GeometryFromEnv()
+
+				if errGrid == nil && grid != nil {
+					errGrid = WriteGeometry(dbc, grid)
+				}
+
+				if errGrid != nil {
+					log.Printf("failed to set grid geometry: %v", errGrid)
+					failure = true
+				}
+			}
+		case "up":
+			if errUp := MigrateUp(dbc, connStr, ParseMigrateOptions(args[1:])); errUp != nil {
+				log.Printf("failed to migrate up: %v", errUp)
+				failure = true
+			}
+		case "down":
+			if errDown := MigrateDown(dbc, connStr, ParseMigrateOptions(args[1:])); errDown != nil {
+				log.Printf("failed to migrate down: %v", errDown)
+				failure = true
+			}
+		case "status":
+			if errStatus := dblib.MigrationStatus(dbc); errStatus != nil {
+				log.Printf("migration status: %v", errStatus)
+				failure = true
 			}
 		case "reset":
 			if errReset := ExecDir(dbc, "./reset"); errReset != nil {
 				log.Printf("failed to reset db: %v", errReset)
 				failure = true
 			}
+		case "expand":
+			if errExpand := ExpandGrid(dbc, connStr, args[1:]); errExpand != nil {
+				log.Printf("failed to expand grid: %v", errExpand)
+				failure = true
+			}
+		case "create-canvas":
+			if errCanvas := CreateCanvas(dbc, args[1:]); errCanvas != nil {
+				log.Printf("failed to create canvas: %v", errCanvas)
+				failure = true
+			}
+		case "drop-canvas":
+			if errCanvas := DropCanvas(dbc, args[1:]); errCanvas != nil {
+				log.Printf("failed to drop canvas: %v", errCanvas)
+				failure = true
+			}
+		case "new-season":
+			if errSeason := StartSeason(dbc, args[1:]); errSeason != nil {
+				log.Printf("failed to start new season: %v", errSeason)
+				failure = true
+			}
+		case "export":
+			if errExport := ExportSnapshot(dbc, args[1:]); errExport != nil {
+				log.Printf("failed to export snapshot: %v", errExport)
+				failure = true
+			}
+		case "import":
+			if errImport := ImportSnapshot(dbc, connStr, args[1:]); errImport != nil {
+				log.Printf("failed to import snapshot: %v", errImport)
+				failure = true
+			}
+		case "fsck":
+			if errFsck := Fsck(dbc, args[1:]); errFsck != nil {
+				log.Printf("fsck: %v", errFsck)
+				failure = true
+			}
+		case "paint":
+			if errPaint := Paint(dbc, args[1:]); errPaint != nil {
+				log.Printf("failed to paint: %v", errPaint)
+				failure = true
+			}
 		case "stats":
 			if errStats := ExecDir(dbc, "./compute_stats"); errStats != nil {
 				log.Printf("failed to compute stats: %v", errStats)
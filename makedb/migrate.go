@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/cmcquillan/one-billion-buttons/dblib"
+)
+
+// The app takes the same lock when it migrates on startup.
+const MIGRATE_LOCK_TIMEOUT = time.Hour
+
+// MigrateOptions are the flags shared by the migration verbs.
+type MigrateOptions struct {
+	DryRun bool
+	Args   []string
+}
+
+func ParseMigrateOptions(args []string) MigrateOptions {
+	opts := MigrateOptions{}
+
+	for _, arg := range args {
+		if arg == "--dry-run" {
+			opts.DryRun = true
+			continue
+		}
+
+		opts.Args = append(opts.Args, arg)
+	}
+
+	return opts
+}
+
+// MigrateUp applies the pending migrations embedded in dblib.
+func MigrateUp(dbc *sql.DB, connStr string, opts MigrateOptions) error {
+	if len(opts.Args) > 0 {
+		return errors.New("usage: up [--dry-run]")
+	}
+
+	return withMigrateLock(dbc, connStr, opts.DryRun, func() error {
+		return dblib.MigrateUp(dbc, opts.DryRun)
+	})
+}
+
+// MigrateDown rolls back the most recently applied migrations, one by default.
+func MigrateDown(dbc *sql.DB, connStr string, opts MigrateOptions) error {
+	if len(opts.Args) > 1 {
+		return errors.New("usage: down [count] [--dry-run]")
+	}
+
+	count := 1
+
+	if len(opts.Args) == 1 {
+		n, err := strconv.Atoi(opts.Args[0])
+
+		if err != nil || n <= 0 {
+			return errors.New("down requires a positive count")
+		}
+
+		count = n
+	}
+
+	return withMigrateLock(dbc, connStr, opts.DryRun, func() error {
+		return dblib.MigrateDown(dbc, count, opts.DryRun)
+	})
+}
+
+func withMigrateLock(dbc *sql.DB, connStr string, dryRun bool, migrate func() error) error {
+	if dryRun {
+		return migrate()
+	}
+
+	if err := dblib.EnsureMigrationTables(dbc); err != nil {
+		return err
+	}
+
+	locker := &dblib.LockSql{ConnStr: connStr}
+
+	lockVal, err := locker.AcquireLock(dblib.MIGRATE_LOCK_TYPE, MIGRATE_LOCK_TIMEOUT)
+
+	if err != nil {
+		return err
+	}
+
+	defer locker.ReleaseLock(lockVal)
+
+	return migrate()
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	pq "github.com/lib/pq"
+)
+
+// Pages written per transaction while painting.
+const PAINT_BATCH_PAGES = 500
+
+type PaintOptions struct {
+	File      string
+	X         int64
+	Y         int64
+	Scale     int64
+	Overwrite bool
+	Canvas    string
+}
+
+type paintPageKey struct {
+	X int64
+	Y int64
+}
+
+// paintPage holds the buttons an image sets on one page, by in-page index.
+type paintPage map[int64][3]byte
+
+func parsePaintOptions(args []string) (*PaintOptions, error) {
+	usage := errors.New("usage: paint <file.png> <x> <y> [--scale=1] [--overwrite] [--canvas=main]")
+	opts := PaintOptions{Scale: 1, Canvas: DEFAULT_CANVAS}
+	positional := make([]string, 0, 3)
+
+	for _, arg := range args {
+		switch {
+		case arg == "--overwrite":
+			opts.Overwrite = true
+		case strings.HasPrefix(arg, "--scale="):
+			scale, err := strconv.ParseInt(strings.TrimPrefix(arg, "--scale="), 10, 64)
+
+			if err != nil || scale <= 0 {
+				return nil, errors.New("--scale must be a positive integer")
+			}
+
+			opts.Scale = scale
+		case strings.HasPrefix(arg, "--canvas="):
+			opts.Canvas = strings.TrimPrefix(arg, "--canvas=")
+		case strings.HasPrefix(arg, "-"):
+			return nil, usage
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) != 3 {
+		return nil, usage
+	}
+
+	x, errX := strconv.ParseInt(positional[1], 10, 64)
+	y, errY := strconv.ParseInt(positional[2], 10, 64)
+
+	if errX != nil || errY != nil || x < 1 || y < 1 {
+		return nil, errors.New("paint requires a positive page coordinate")
+	}
+
+	opts.File, opts.X, opts.Y = positional[0], x, y
+	return &opts, nil
+}
+
+// Paint draws a PNG onto a canvas with its top left corner on the first
+// button of page (x, y). Each image pixel covers scale x scale buttons, and
+// buttons sit in a page row-major, sqrt(buttons_per_page) to a side, the same
+// way the UI lays them out. Like a press, a pixel only lands on an unpressed
+// button unless --overwrite is given. Transparent pixels are skipped.
+func Paint(dbc *sql.DB, args []string) error {
+	opts, err := parsePaintOptions(args)
+
+	if err != nil {
+		return err
+	}
+
+	var cols, rows, idCols, bpp int64
+	var readOnly bool
+
+	row := dbc.QueryRow(`
+		SELECT c.cols, c.rows, c.id_cols, c.read_only, g.buttons_per_page
+		FROM canvas c
+		CROSS JOIN grid_geometry g
+		WHERE g.id = 1 AND c.name = $1`, opts.Canvas)
+
+	if err := row.Scan(&cols, &rows, &idCols, &readOnly, &bpp); err != nil {
+		return fmt.Errorf("could not read canvas %s: %w", opts.Canvas, err)
+	}
+
+	if readOnly {
+		return fmt.Errorf("canvas %s is archived and read only", opts.Canvas)
+	}
+
+	side := int64(math.Sqrt(float64(bpp)))
+
+	if side*side != bpp {
+		return fmt.Errorf("pages of %d buttons are not square and cannot be painted", bpp)
+	}
+
+	file, err := os.Open(opts.File)
+
+	if err != nil {
+		return err
+	}
+
+	img, err := png.Decode(file)
+	file.Close()
+
+	if err != nil {
+		return err
+	}
+
+	pages, clipped := mapImageToPages(img, opts, side, cols, rows)
+
+	if clipped > 0 {
+		log.Printf("%d buttons fall outside of the canvas and are skipped", clipped)
+	}
+
+	keys := make([]paintPageKey, 0, len(pages))
+
+	for key := range pages {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a paintPageKey, b paintPageKey) int {
+		if a.Y != b.Y {
+			return int(a.Y - b.Y)
+		}
+
+		return int(a.X - b.X)
+	})
+
+	log.Printf("painting %s onto %d pages of canvas %s", opts.File, len(keys), opts.Canvas)
+
+	start := time.Now()
+	pressed := int64(0)
+
+	for batch := range slices.Chunk(keys, PAINT_BATCH_PAGES) {
+		n, err := paintBatch(dbc, opts, idCols, bpp, batch, pages)
+
+		if err != nil {
+			return err
+		}
+
+		pressed += n
+		log.Printf("painted %d buttons...", pressed)
+	}
+
+	log.Printf("painted %d buttons on canvas %s in %v", pressed, opts.Canvas, time.Since(start))
+	return nil
+}
+
+func mapImageToPages(img image.Image, opts *PaintOptions, side int64, cols int64, rows int64) (map[paintPageKey]paintPage, int64) {
+	pages := make(map[paintPageKey]paintPage)
+	clipped := int64(0)
+
+	originX := (opts.X - 1) * side
+	originY := (opts.Y - 1) * side
+	bounds := img.Bounds()
+
+	for py := bounds.Min.Y; py < bounds.Max.Y; py++ {
+		for px := bounds.Min.X; px < bounds.Max.X; px++ {
+			r, g, b, a := img.At(px, py).RGBA()
+
+			if a < 0x8000 {
+				continue
+			}
+
+			// Black is the unpressed color, so it is drawn as the closest pressed one
+			rgb := [3]byte{byte(r >> 8), byte(g >> 8), byte(b >> 8)}
+
+			if rgb == [3]byte{0, 0, 0} {
+				rgb = [3]byte{1, 1, 1}
+			}
+
+			for dy := int64(0); dy < opts.Scale; dy++ {
+				for dx := int64(0); dx < opts.Scale; dx++ {
+					bx := originX + int64(px-bounds.Min.X)*opts.Scale + dx
+					by := originY + int64(py-bounds.Min.Y)*opts.Scale + dy
+					key := paintPageKey{X: bx/side + 1, Y: by/side + 1}
+
+					if key.X > cols || key.Y > rows {
+						clipped++
+						continue
+					}
+
+					if pages[key] == nil {
+						pages[key] = make(paintPage)
+					}
+
+					pages[key][(by%side)*side+bx%side] = rgb
+				}
+			}
+		}
+	}
+
+	return pages, clipped
+}
+
+// paintBatch applies the pixels of a batch of pages in one transaction. Each
+// painted button bumps the page version and is logged as a press, exactly as
+// if it had been pressed through the API.
+func paintBatch(dbc *sql.DB, opts *PaintOptions, idCols int64, bpp int64, keys []paintPageKey, pages map[paintPageKey]paintPage) (int64, error) {
+	tx, err := dbc.Begin()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	xs := make([]int64, len(keys))
+	ys := make([]int64, len(keys))
+
+	for i, key := range keys {
+		xs[i], ys[i] = key.X, key.Y
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO button (canvas, x_coord, y_coord)
+		SELECT $1, p.x, p.y FROM unnest($2::int[], $3::int[]) AS p(x, y)
+		ON CONFLICT (canvas, x_coord, y_coord) DO NOTHING`,
+		opts.Canvas, pq.Array(xs), pq.Array(ys))
+
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT x_coord, y_coord, version, buttons, pressed_at, pressed_version
+		FROM button
+		WHERE canvas = $1
+		AND (x_coord, y_coord) IN (SELECT * FROM unnest($2::int[], $3::int[]))
+		FOR UPDATE`,
+		opts.Canvas, pq.Array(xs), pq.Array(ys))
+
+	if err != nil {
+		return 0, err
+	}
+
+	type pageState struct {
+		key            paintPageKey
+		version        int64
+		buttons        []byte
+		pressedAt      []byte
+		pressedVersion []byte
+	}
+
+	states := make([]pageState, 0, len(keys))
+
+	for rows.Next() {
+		s := pageState{}
+
+		if err := rows.Scan(&s.key.X, &s.key.Y, &s.version, &s.buttons, &s.pressedAt, &s.pressedVersion); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		states = append(states, s)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	update, err := tx.Prepare(`
+		UPDATE button SET
+			buttons = $4,
+			version = $5,
+			pressed_at = $6,
+			pressed_version = $7,
+			map_value = get_minimap_color($4::fixed_bytea),
+			change_seq = nextval('button_change_seq')
+		WHERE canvas = $1 AND x_coord = $2 AND y_coord = $3`)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer update.Close()
+
+	type pressEvent struct {
+		x  int64
+		y  int64
+		id int64
+	}
+
+	events := make([]pressEvent, 0)
+	now := uint32(time.Now().Unix())
+
+	for _, s := range states {
+		changed := false
+		indexes := make([]int64, 0, len(pages[s.key]))
+
+		for ix := range pages[s.key] {
+			indexes = append(indexes, ix)
+		}
+
+		slices.Sort(indexes)
+
+		for _, ix := range indexes {
+			rgb := pages[s.key][ix]
+			current := s.buttons[ix*3 : ix*3+3]
+
+			if !opts.Overwrite && (current[0] != 0 || current[1] != 0 || current[2] != 0) {
+				continue
+			}
+
+			if current[0] == rgb[0] && current[1] == rgb[1] && current[2] == rgb[2] {
+				continue
+			}
+
+			s.version++
+			copy(current, rgb[:])
+			binary.BigEndian.PutUint32(s.pressedAt[ix*4:], now)
+			binary.BigEndian.PutUint32(s.pressedVersion[ix*4:], uint32(s.version))
+			changed = true
+
+			id := (s.key.Y-1)*idCols*bpp + (s.key.X-1)*bpp + ix
+			events = append(events, pressEvent{x: s.key.X, y: s.key.Y, id: id})
+		}
+
+		if !changed {
+			continue
+		}
+
+		_, err := update.Exec(opts.Canvas, s.key.X, s.key.Y, s.buttons, s.version, s.pressedAt, s.pressedVersion)
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Pages created above that ended up untouched stay sparse
+	_, err = tx.Exec(`
+		DELETE FROM button
+		WHERE canvas = $1 AND version = 0
+		AND (x_coord, y_coord) IN (SELECT * FROM unnest($2::int[], $3::int[]))`,
+		opts.Canvas, pq.Array(xs), pq.Array(ys))
+
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("button_event", "canvas", "x_coord", "y_coord", "button_id", "event_type"))
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, evt := range events {
+		if _, err := stmt.Exec(opts.Canvas, evt.x, evt.y, evt.id, "press"); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := stmt.Exec(); err != nil {
+		return 0, err
+	}
+
+	if err := stmt.Close(); err != nil {
+		return 0, err
+	}
+
+	return int64(len(events)), tx.Commit()
+}
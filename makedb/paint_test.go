@@ -0,0 +1,112 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+var (
+	paintRed   = color.NRGBA{0xff, 0x00, 0x00, 0xff}
+	paintBlue  = color.NRGBA{0x00, 0x00, 0xff, 0xff}
+	paintBlack = color.NRGBA{0x00, 0x00, 0x00, 0xff}
+	paintClear = color.NRGBA{0xff, 0xff, 0xff, 0x00}
+)
+
+// paintImage is a w x h image with its pixels set row by row.
+func paintImage(w int, h int, pixels ...color.NRGBA) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	for i, c := range pixels {
+		img.SetNRGBA(i%w, i/w, c)
+	}
+
+	return img
+}
+
+func TestMapImageToPages(t *testing.T) {
+	red := [3]byte{0xff, 0x00, 0x00}
+	blue := [3]byte{0x00, 0x00, 0xff}
+
+	// Pages of 2x2 buttons on a canvas of 2x2 pages
+	tests := []struct {
+		name            string
+		img             image.Image
+		opts            PaintOptions
+		expectedPages   map[paintPageKey]paintPage
+		expectedClipped int64
+	}{
+		{
+			name:          "pixel on the first button",
+			img:           paintImage(1, 1, paintRed),
+			opts:          PaintOptions{X: 1, Y: 1, Scale: 1},
+			expectedPages: map[paintPageKey]paintPage{{1, 1}: {0: red}},
+		},
+		{
+			name: "pixels fill a page row-major",
+			img:  paintImage(2, 2, paintRed, paintBlue, paintBlue, paintRed),
+			opts: PaintOptions{X: 2, Y: 1, Scale: 1},
+			expectedPages: map[paintPageKey]paintPage{
+				{2, 1}: {0: red, 1: blue, 2: blue, 3: red},
+			},
+		},
+		{
+			name: "image spans pages",
+			img:  paintImage(3, 1, paintRed, paintBlue, paintRed),
+			opts: PaintOptions{X: 1, Y: 2, Scale: 1},
+			expectedPages: map[paintPageKey]paintPage{
+				{1, 2}: {0: red, 1: blue},
+				{2, 2}: {0: red},
+			},
+		},
+		{
+			name: "scale covers several buttons with one pixel",
+			img:  paintImage(1, 1, paintBlue),
+			opts: PaintOptions{X: 1, Y: 1, Scale: 2},
+			expectedPages: map[paintPageKey]paintPage{
+				{1, 1}: {0: blue, 1: blue, 2: blue, 3: blue},
+			},
+		},
+		{
+			name:          "black is drawn as the closest pressed color",
+			img:           paintImage(1, 1, paintBlack),
+			opts:          PaintOptions{X: 1, Y: 1, Scale: 1},
+			expectedPages: map[paintPageKey]paintPage{{1, 1}: {0: {0x01, 0x01, 0x01}}},
+		},
+		{
+			name:          "transparent pixels are skipped",
+			img:           paintImage(2, 1, paintClear, paintRed),
+			opts:          PaintOptions{X: 1, Y: 1, Scale: 1},
+			expectedPages: map[paintPageKey]paintPage{{1, 1}: {1: red}},
+		},
+		{
+			name:            "buttons past the canvas are clipped",
+			img:             paintImage(3, 3, paintRed, paintRed, paintRed, paintRed, paintRed, paintRed, paintRed, paintRed, paintRed),
+			opts:            PaintOptions{X: 2, Y: 2, Scale: 1},
+			expectedPages:   map[paintPageKey]paintPage{{2, 2}: {0: red, 1: red, 2: red, 3: red}},
+			expectedClipped: 5,
+		},
+		{
+			name:            "image entirely off the canvas",
+			img:             paintImage(1, 1, paintRed),
+			opts:            PaintOptions{X: 3, Y: 1, Scale: 2},
+			expectedPages:   map[paintPageKey]paintPage{},
+			expectedClipped: 4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pages, clipped := mapImageToPages(test.img, &test.opts, 2, 2, 2)
+
+			if clipped != test.expectedClipped {
+				t.Errorf("expected %d clipped buttons, got %d", test.expectedClipped, clipped)
+			}
+
+			if !reflect.DeepEqual(pages, test.expectedPages) {
+				t.Errorf("expected pages %v, got %v", test.expectedPages, pages)
+			}
+		})
+	}
+}
@@ -5,12 +5,28 @@ DROP PROCEDURE IF EXISTS public.set_button_color;
 
 DROP PROCEDURE IF EXISTS public.update_button_stats;
 
+DROP PROCEDURE IF EXISTS public.expand_grid;
+
+DROP PROCEDURE IF EXISTS public.create_canvas;
+
+DROP PROCEDURE IF EXISTS public.drop_canvas;
+
+DROP PROCEDURE IF EXISTS public.start_season;
+
 DROP TABLE IF EXISTS public.button_event;
 
 DROP TABLE IF EXISTS public.button_stat;
 
 DROP TABLE IF EXISTS public.button;
 
+DROP SEQUENCE IF EXISTS public.button_change_seq;
+
+DROP TABLE IF EXISTS public.grid_geometry;
+
+DROP TABLE IF EXISTS public.canvas;
+
+DROP TABLE IF EXISTS public.schema_migrations;
+
 DROP DOMAIN IF EXISTS fixed_bytea;
 
 END $$;
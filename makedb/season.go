@@ -0,0 +1,35 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+)
+
+// StartSeason archives a canvas as the read-only '<canvas>-s<season>' and
+// resets it to a blank grid with zeroed stats.
+func StartSeason(dbc *sql.DB, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: new-season [canvas]")
+	}
+
+	canvas := DEFAULT_CANVAS
+
+	if len(args) == 1 {
+		canvas = args[0]
+	}
+
+	var season int64
+	row := dbc.QueryRow("SELECT season FROM canvas WHERE name = $1", canvas)
+
+	if err := row.Scan(&season); err != nil {
+		return err
+	}
+
+	if _, err := dbc.Exec("call start_season($1)", canvas); err != nil {
+		return err
+	}
+
+	log.Printf("archived season %d of canvas %s", season, canvas)
+	return nil
+}
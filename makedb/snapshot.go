@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	pq "github.com/lib/pq"
+)
+
+// A snapshot is a gzip stream holding, in order:
+//
+//	8 byte magic "OBBSNAP" + format version
+//	uint32 length + JSON SnapshotHeader (geometry and canvases)
+//	one record per page: uint8 canvas name length, canvas name, uint32 x,
+//	uint32 y, uint32 version, then the buttons, pressed_at and
+//	pressed_version columns
+//	a record with a zero length canvas name, uint64 page count and the
+//	sha256 of everything before it
+//
+// All integers are big-endian. Events and stats are not part of a snapshot.
+var snapshotMagic = []byte{'O', 'B', 'B', 'S', 'N', 'A', 'P', SNAPSHOT_FORMAT_VERSION}
+
+const SNAPSHOT_FORMAT_VERSION = 1
+
+type SnapshotHeader struct {
+	CreatedAt      time.Time        `json:"created_at"`
+	ButtonsPerPage int64            `json:"buttons_per_page"`
+	Canvases       []SnapshotCanvas `json:"canvases"`
+}
+
+type SnapshotCanvas struct {
+	Name         string     `json:"name"`
+	Cols         int64      `json:"cols"`
+	Rows         int64      `json:"rows"`
+	IdCols       int64      `json:"id_cols"`
+	Season       int64      `json:"season"`
+	ReadOnly     bool       `json:"read_only"`
+	ArchivedFrom *string    `json:"archived_from,omitempty"`
+	ArchivedAt   *time.Time `json:"archived_at,omitempty"`
+}
+
+type snapshotPage struct {
+	Canvas         string
+	X              uint32
+	Y              uint32
+	Version        uint32
+	Buttons        []byte
+	PressedAt      []byte
+	PressedVersion []byte
+}
+
+// ExportSnapshot writes every page of every canvas to a snapshot file. The
+// pages are read in a single repeatable read transaction so the snapshot is
+// consistent while the app keeps serving.
+func ExportSnapshot(dbc *sql.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: export <file>")
+	}
+
+	tx, err := dbc.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	header, err := readSnapshotHeader(tx)
+
+	if err != nil {
+		return err
+	}
+
+	tmpFile := args[0] + ".tmp"
+	file, err := os.Create(tmpFile)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmpFile)
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	hasher := sha256.New()
+	w := bufio.NewWriterSize(io.MultiWriter(gz, hasher), 1<<20)
+
+	if err := writeSnapshotHeader(w, header); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`
+		SELECT canvas, x_coord, y_coord, version, buttons, pressed_at, pressed_version
+		FROM button
+		ORDER BY canvas, y_coord, x_coord`)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	pages := uint64(0)
+	start := time.Now()
+
+	for rows.Next() {
+		p := snapshotPage{}
+
+		if err := rows.Scan(&p.Canvas, &p.X, &p.Y, &p.Version, &p.Buttons, &p.PressedAt, &p.PressedVersion); err != nil {
+			return err
+		}
+
+		if err := writeSnapshotPage(w, &p); err != nil {
+			return err
+		}
+
+		pages++
+
+		if pages%100000 == 0 {
+			log.Printf("exported %d pages...", pages)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := writeSnapshotTrailer(w, gz, hasher, pages); err != nil {
+		return err
+	}
+
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpFile, args[0]); err != nil {
+		return err
+	}
+
+	log.Printf("exported %d pages of %d canvases to %s in %v", pages, len(header.Canvases), args[0], time.Since(start))
+	return nil
+}
+
+func readSnapshotHeader(tx *sql.Tx) (*SnapshotHeader, error) {
+	header := SnapshotHeader{CreatedAt: time.Now().UTC()}
+
+	row := tx.QueryRow("SELECT buttons_per_page FROM grid_geometry WHERE id = 1")
+
+	if err := row.Scan(&header.ButtonsPerPage); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT name, cols, rows, id_cols, season, read_only, archived_from, archived_at
+		FROM canvas
+		ORDER BY name`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		c := SnapshotCanvas{}
+
+		if err := rows.Scan(&c.Name, &c.Cols, &c.Rows, &c.IdCols, &c.Season, &c.ReadOnly, &c.ArchivedFrom, &c.ArchivedAt); err != nil {
+			return nil, err
+		}
+
+		header.Canvases = append(header.Canvases, c)
+	}
+
+	return &header, rows.Err()
+}
+
+func writeSnapshotHeader(w *bufio.Writer, header *SnapshotHeader) error {
+	headerJson, err := json.Marshal(header)
+
+	if err != nil {
+		return err
+	}
+
+	w.Write(snapshotMagic)
+	binary.Write(w, binary.BigEndian, uint32(len(headerJson)))
+	_, err = w.Write(headerJson)
+
+	return err
+}
+
+func writeSnapshotPage(w *bufio.Writer, p *snapshotPage) error {
+	w.WriteByte(byte(len(p.Canvas)))
+	w.WriteString(p.Canvas)
+	binary.Write(w, binary.BigEndian, p.X)
+	binary.Write(w, binary.BigEndian, p.Y)
+	binary.Write(w, binary.BigEndian, p.Version)
+	w.Write(p.Buttons)
+	w.Write(p.PressedAt)
+	_, err := w.Write(p.PressedVersion)
+
+	return err
+}
+
+// writeSnapshotTrailer ends the pages of w, which hashes into hasher, and
+// writes the checksum straight to out, below w, so it is not hashed itself.
+func writeSnapshotTrailer(w *bufio.Writer, out io.Writer, hasher hash.Hash, pages uint64) error {
+	w.WriteByte(0)
+	binary.Write(w, binary.BigEndian, pages)
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	_, err := out.Write(hasher.Sum(nil))
+
+	return err
+}
+
+// ImportSnapshot restores a snapshot file. An empty database is created with
+// the snapshot's geometry first. Pages in the snapshot replace the ones in the
+// database, and with --replace every other page of the imported canvases is
+// removed too. Nothing is written unless the whole file checks out.
+func ImportSnapshot(dbc *sql.DB, connStr string, args []string) error {
+	replace := slices.Contains(args, "--replace")
+	args = slices.DeleteFunc(slices.Clone(args), func(a string) bool { return a == "--replace" })
+
+	if len(args) != 1 {
+		return errors.New("usage: import <file> [--replace]")
+	}
+
+	file, err := os.Open(args[0])
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+
+	if err != nil {
+		return err
+	}
+
+	src := bufio.NewReaderSize(gz, 1<<20)
+	hasher := sha256.New()
+	r := io.TeeReader(src, hasher)
+
+	header, err := readSnapshotFileHeader(r)
+
+	if err != nil {
+		return err
+	}
+
+	if err := prepareImportSchema(dbc, connStr, header); err != nil {
+		return err
+	}
+
+	tx, err := dbc.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		CREATE TEMPORARY TABLE snapshot_page (
+			canvas varchar(20) NOT NULL,
+			x_coord int NOT NULL,
+			y_coord int NOT NULL,
+			version int NOT NULL,
+			buttons bytea NOT NULL,
+			pressed_at bytea NOT NULL,
+			pressed_version bytea NOT NULL
+		) ON COMMIT DROP`)
+
+	if err != nil {
+		return err
+	}
+
+	pages, err := copySnapshotPages(tx, r, src, hasher, header)
+
+	if err != nil {
+		return err
+	}
+
+	if err := importCanvases(tx, header, replace); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO button (canvas, x_coord, y_coord, version, buttons, pressed_at, pressed_version, map_value)
+		SELECT canvas, x_coord, y_coord, version, buttons, pressed_at, pressed_version, get_minimap_color(buttons::fixed_bytea)
+		FROM snapshot_page
+		ON CONFLICT (canvas, x_coord, y_coord) DO UPDATE SET
+			version = excluded.version,
+			buttons = excluded.buttons,
+			pressed_at = excluded.pressed_at,
+			pressed_version = excluded.pressed_version,
+			map_value = excluded.map_value,
+			change_seq = nextval('button_change_seq')`)
+
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("imported %d pages of %d canvases from %s", pages, len(header.Canvases), args[0])
+	return nil
+}
+
+func readSnapshotFileHeader(r io.Reader) (*SnapshotHeader, error) {
+	magic := make([]byte, len(snapshotMagic))
+
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic, snapshotMagic) {
+		return nil, errors.New("not a snapshot file, or written by an incompatible makedb")
+	}
+
+	var headerLen uint32
+
+	if err := binary.Read(r, binary.BigEndian, &headerLen); err != nil {
+		return nil, err
+	}
+
+	headerJson := make([]byte, headerLen)
+
+	if _, err := io.ReadFull(r, headerJson); err != nil {
+		return nil, err
+	}
+
+	header := SnapshotHeader{}
+
+	if err := json.Unmarshal(headerJson, &header); err != nil {
+		return nil, err
+	}
+
+	if header.ButtonsPerPage <= 0 {
+		return nil, errors.New("snapshot has an invalid page size")
+	}
+
+	for _, c := range header.Canvases {
+		if c.Cols <= 0 || c.Rows <= 0 || c.IdCols < c.Cols {
+			return nil, fmt.Errorf("snapshot has an invalid geometry for canvas %s", c.Name)
+		}
+	}
+
+	log.Printf("snapshot taken at %v with %d canvases of %d buttons per page", header.CreatedAt, len(header.Canvases), header.ButtonsPerPage)
+	return &header, nil
+}
+
+// prepareImportSchema migrates the database and resizes the pages of an
+// empty one to the snapshot's. A grid with pages of another size is refused.
+func prepareImportSchema(dbc *sql.DB, connStr string, header *SnapshotHeader) error {
+	if err := MigrateUp(dbc, connStr, MigrateOptions{}); err != nil {
+		return err
+	}
+
+	current, err := ReadGeometry(dbc)
+
+	if err != nil {
+		return err
+	}
+
+	if current.ButtonsPerPage == header.ButtonsPerPage {
+		return nil
+	}
+
+	log.Printf("resizing pages from %d to %d buttons", current.ButtonsPerPage, header.ButtonsPerPage)
+
+	if _, err := dbc.Exec("CALL set_page_size($1)", header.ButtonsPerPage); err != nil {
+		return fmt.Errorf("snapshot has %d buttons per page, database has %d: %w", header.ButtonsPerPage, current.ButtonsPerPage, err)
+	}
+
+	return nil
+}
+
+func copySnapshotPages(tx *sql.Tx, r io.Reader, src *bufio.Reader, hasher hash.Hash, header *SnapshotHeader) (uint64, error) {
+	stmt, err := tx.Prepare(pq.CopyIn("snapshot_page", "canvas", "x_coord", "y_coord", "version", "buttons", "pressed_at", "pressed_version"))
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer stmt.Close()
+
+	pages, err := readSnapshotPages(r, src, hasher, header, func(p *snapshotPage) error {
+		_, err := stmt.Exec(p.Canvas, p.X, p.Y, p.Version, p.Buttons, p.PressedAt, p.PressedVersion)
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := stmt.Exec(); err != nil {
+		return 0, err
+	}
+
+	return pages, nil
+}
+
+// readSnapshotPages hands every page after the header to page and checks the
+// trailer. r hashes into hasher as it reads from src, and the checksum is read
+// from src directly. Pages already handed over are only good once it returns
+// without an error.
+func readSnapshotPages(r io.Reader, src *bufio.Reader, hasher hash.Hash, header *SnapshotHeader, page func(p *snapshotPage) error) (uint64, error) {
+	known := make(map[string]SnapshotCanvas)
+
+	for _, c := range header.Canvases {
+		known[c.Name] = c
+	}
+
+	bpp := header.ButtonsPerPage
+	pages := uint64(0)
+
+	for {
+		nameLen := make([]byte, 1)
+
+		if _, err := io.ReadFull(r, nameLen); err != nil {
+			return 0, fmt.Errorf("snapshot is truncated: %w", err)
+		}
+
+		if nameLen[0] == 0 {
+			break
+		}
+
+		p := snapshotPage{
+			Buttons:        make([]byte, bpp*3),
+			PressedAt:      make([]byte, bpp*4),
+			PressedVersion: make([]byte, bpp*4),
+		}
+
+		name := make([]byte, nameLen[0])
+		_, err := io.ReadFull(r, name)
+
+		for _, field := range []any{&p.X, &p.Y, &p.Version} {
+			if err == nil {
+				err = binary.Read(r, binary.BigEndian, field)
+			}
+		}
+
+		for _, field := range [][]byte{p.Buttons, p.PressedAt, p.PressedVersion} {
+			if err == nil {
+				_, err = io.ReadFull(r, field)
+			}
+		}
+
+		if err != nil {
+			return 0, fmt.Errorf("snapshot is truncated: %w", err)
+		}
+
+		p.Canvas = string(name)
+
+		c, found := known[p.Canvas]
+
+		if !found {
+			return 0, fmt.Errorf("snapshot page %d,%d belongs to unknown canvas %s", p.X, p.Y, p.Canvas)
+		}
+
+		if p.X < 1 || p.Y < 1 || int64(p.X) > c.Cols || int64(p.Y) > c.Rows {
+			return 0, fmt.Errorf("snapshot page %d,%d is outside the %dx%d canvas %s", p.X, p.Y, c.Cols, c.Rows, p.Canvas)
+		}
+
+		if err := page(&p); err != nil {
+			return 0, err
+		}
+
+		pages++
+
+		if pages%100000 == 0 {
+			log.Printf("read %d pages...", pages)
+		}
+	}
+
+	var count uint64
+
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return 0, fmt.Errorf("snapshot is truncated: %w", err)
+	}
+
+	expected := hasher.Sum(nil)
+	sum := make([]byte, sha256.Size)
+
+	// Read from src so the checksum itself is not hashed
+	if _, err := io.ReadFull(src, sum); err != nil {
+		return 0, fmt.Errorf("snapshot is truncated: %w", err)
+	}
+
+	if !bytes.Equal(sum, expected) || count != pages {
+		return 0, errors.New("snapshot checksum does not match, the file is corrupt")
+	}
+
+	return pages, nil
+}
+
+// importCanvases creates or updates the canvases of the snapshot, seeding
+// stats for new ones the same way create_canvas does.
+func importCanvases(tx *sql.Tx, header *SnapshotHeader, replace bool) error {
+	for _, c := range header.Canvases {
+		_, err := tx.Exec(`
+			INSERT INTO canvas (name, cols, rows, id_cols, season, read_only, archived_from, archived_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (name) DO UPDATE SET
+				cols = excluded.cols,
+				rows = excluded.rows,
+				id_cols = excluded.id_cols,
+				season = excluded.season,
+				read_only = excluded.read_only,
+				archived_from = excluded.archived_from,
+				archived_at = excluded.archived_at`,
+			c.Name, c.Cols, c.Rows, c.IdCols, c.Season, c.ReadOnly, c.ArchivedFrom, c.ArchivedAt)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO button_stat (canvas, stat_key, stat_name, stat_desc, val, "scale", "order")
+			SELECT $1, stat_key, stat_name, stat_desc, 0, "scale", "order"
+			FROM button_stat
+			WHERE canvas = $2
+			ON CONFLICT DO NOTHING`, c.Name, DEFAULT_CANVAS)
+
+		if err != nil {
+			return err
+		}
+
+		if replace {
+			if _, err := tx.Exec("DELETE FROM button WHERE canvas = $1", c.Name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
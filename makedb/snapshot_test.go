@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSnapshotHeader = SnapshotHeader{
+	CreatedAt:      time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC),
+	ButtonsPerPage: 2,
+	Canvases: []SnapshotCanvas{
+		{Name: "main", Cols: 4, Rows: 2, IdCols: 8, Season: 1},
+	},
+}
+
+func testSnapshotPage(canvas string, x uint32, y uint32) *snapshotPage {
+	return &snapshotPage{
+		Canvas:         canvas,
+		X:              x,
+		Y:              y,
+		Version:        x + y,
+		Buttons:        []byte{byte(x), byte(y), 0, 0, 0, 0},
+		PressedAt:      []byte{0, 0, 0, 1, 0, 0, 0, 0},
+		PressedVersion: []byte{0, 0, 0, 2, 0, 0, 0, 0},
+	}
+}
+
+// writeTestSnapshot writes an uncompressed snapshot stream, as found inside
+// the gzip of an exported file.
+func writeTestSnapshot(t *testing.T, header *SnapshotHeader, pages ...*snapshotPage) []byte {
+	out := &bytes.Buffer{}
+	hasher := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(out, hasher))
+
+	if err := writeSnapshotHeader(w, header); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, p := range pages {
+		if err := writeSnapshotPage(w, p); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if err := writeSnapshotTrailer(w, out, hasher, uint64(len(pages))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return out.Bytes()
+}
+
+func readTestSnapshot(data []byte) (*SnapshotHeader, []*snapshotPage, error) {
+	src := bufio.NewReader(bytes.NewReader(data))
+	hasher := sha256.New()
+	r := io.TeeReader(src, hasher)
+
+	header, err := readSnapshotFileHeader(r)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pages := make([]*snapshotPage, 0)
+
+	_, err = readSnapshotPages(r, src, hasher, header, func(p *snapshotPage) error {
+		pages = append(pages, p)
+		return nil
+	})
+
+	return header, pages, err
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	written := []*snapshotPage{testSnapshotPage("main", 1, 1), testSnapshotPage("main", 4, 2)}
+	header, pages, err := readTestSnapshot(writeTestSnapshot(t, &testSnapshotHeader, written...))
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !header.CreatedAt.Equal(testSnapshotHeader.CreatedAt) || header.ButtonsPerPage != 2 || len(header.Canvases) != 1 || header.Canvases[0] != testSnapshotHeader.Canvases[0] {
+		t.Errorf("unexpected header %+v", header)
+	}
+
+	if len(pages) != len(written) {
+		t.Fatalf("expected %d pages, got %d", len(written), len(pages))
+	}
+
+	for i, p := range pages {
+		w := written[i]
+
+		if p.Canvas != w.Canvas || p.X != w.X || p.Y != w.Y || p.Version != w.Version ||
+			!bytes.Equal(p.Buttons, w.Buttons) || !bytes.Equal(p.PressedAt, w.PressedAt) || !bytes.Equal(p.PressedVersion, w.PressedVersion) {
+			t.Errorf("page %d: expected %+v, got %+v", i, w, p)
+		}
+	}
+}
+
+func TestSnapshotChecksumMismatch(t *testing.T) {
+	data := writeTestSnapshot(t, &testSnapshotHeader, testSnapshotPage("main", 1, 1))
+
+	// Flip a button byte of the page, which the checksum covers
+	corrupt := bytes.Clone(data)
+	corrupt[len(corrupt)-sha256.Size-9-8-8-1] ^= 0xff
+
+	if _, _, err := readTestSnapshot(corrupt); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected a checksum error, got %v", err)
+	}
+
+	corrupt = bytes.Clone(data)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	if _, _, err := readTestSnapshot(corrupt); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected a checksum error for a corrupt checksum, got %v", err)
+	}
+}
+
+func TestSnapshotTruncated(t *testing.T) {
+	data := writeTestSnapshot(t, &testSnapshotHeader, testSnapshotPage("main", 1, 1), testSnapshotPage("main", 2, 1))
+
+	// Cut in the header, inside a page, in the trailer and in the checksum
+	for _, cut := range []int{4, len(data) / 2, len(data) - sha256.Size - 4, len(data) - 1} {
+		if _, _, err := readTestSnapshot(data[:cut]); err == nil {
+			t.Errorf("expected error for a snapshot cut at %d of %d bytes", cut, len(data))
+		}
+	}
+}
+
+func TestSnapshotUnknownCanvas(t *testing.T) {
+	data := writeTestSnapshot(t, &testSnapshotHeader, testSnapshotPage("main", 1, 1), testSnapshotPage("event", 1, 1))
+
+	if _, _, err := readTestSnapshot(data); err == nil || !strings.Contains(err.Error(), "unknown canvas event") {
+		t.Errorf("expected an unknown canvas error, got %v", err)
+	}
+}
+
+func TestSnapshotPageOutsideCanvas(t *testing.T) {
+	for _, p := range []*snapshotPage{testSnapshotPage("main", 5, 1), testSnapshotPage("main", 1, 3), testSnapshotPage("main", 0, 1)} {
+		data := writeTestSnapshot(t, &testSnapshotHeader, p)
+		expected := fmt.Sprintf("page %d,%d is outside the 4x2 canvas main", p.X, p.Y)
+
+		if _, _, err := readTestSnapshot(data); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	}
+}
+
+func TestSnapshotFileHeaderRejectsOtherFiles(t *testing.T) {
+	if _, err := readSnapshotFileHeader(strings.NewReader("PK\x03\x04 not a snapshot")); err == nil {
+		t.Error("expected error for a file without the snapshot magic")
+	}
+
+	invalid := testSnapshotHeader
+	invalid.ButtonsPerPage = 0
+
+	if _, _, err := readTestSnapshot(writeTestSnapshot(t, &invalid)); err == nil {
+		t.Error("expected error for a snapshot without a page size")
+	}
+
+	invalid = testSnapshotHeader
+	invalid.Canvases = []SnapshotCanvas{{Name: "main", Cols: 4, Rows: 2, Season: 1}}
+
+	if _, _, err := readTestSnapshot(writeTestSnapshot(t, &invalid)); err == nil {
+		t.Error("expected error for a canvas without an id stride")
+	}
+}
@@ -0,0 +1,214 @@
+package minimaplib
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"log"
+	"slices"
+	"time"
+)
+
+// ArchiveConfig is how long minimap snapshots are kept and how far apart
+// they are taken.
+type ArchiveConfig struct {
+	// 0 keeps no snapshots
+	MinimapArchiveRetention time.Duration `envconfig:"MINIMAP_ARCHIVE_RETENTION" default:"168h"`
+
+	// 0 keeps every drawn minimap, incremental runs included
+	MinimapArchiveInterval time.Duration `envconfig:"MINIMAP_ARCHIVE_INTERVAL" default:"10m"`
+}
+
+// SNAPSHOT_ID_FORMAT names snapshots by the time their minimap was drawn, so
+// ids sort by time.
+const SNAPSHOT_ID_FORMAT = "20060102T150405Z"
+
+// Snapshot is one archived minimap PNG.
+type Snapshot struct {
+	Id      string    `json:"id"`
+	TakenAt time.Time `json:"taken_at"`
+	Width   int64     `json:"width"`
+	Height  int64     `json:"height"`
+	ETag    string    `json:"etag"`
+}
+
+// SnapshotIndex lists the archived minimaps of a canvas, oldest first. The
+// store cannot list objects, so the index is kept next to them.
+type SnapshotIndex struct {
+	Canvas    string     `json:"canvas"`
+	Snapshots []Snapshot `json:"snapshots"`
+}
+
+func SnapshotName(canvas string, id string) string {
+	return "archive/" + canvas + "/" + id + ".png"
+}
+
+func SnapshotIndexName(canvas string) string {
+	return "archive/" + canvas + "/index.json"
+}
+
+// Find returns the snapshot with an id, or nil.
+func (idx *SnapshotIndex) Find(id string) *Snapshot {
+	i := slices.IndexFunc(idx.Snapshots, func(s Snapshot) bool {
+		return s.Id == id
+	})
+
+	if i < 0 {
+		return nil
+	}
+
+	return &idx.Snapshots[i]
+}
+
+// Before returns the latest snapshot taken at or before t, or nil.
+func (idx *SnapshotIndex) Before(t time.Time) *Snapshot {
+	for i := len(idx.Snapshots) - 1; i >= 0; i-- {
+		if !idx.Snapshots[i].TakenAt.After(t) {
+			return &idx.Snapshots[i]
+		}
+	}
+
+	return nil
+}
+
+// Latest returns the newest snapshot, or nil.
+func (idx *SnapshotIndex) Latest() *Snapshot {
+	if len(idx.Snapshots) == 0 {
+		return nil
+	}
+
+	return &idx.Snapshots[len(idx.Snapshots)-1]
+}
+
+// ReadSnapshotIndex reads the snapshot index of a canvas. A canvas that was
+// never archived has an empty one.
+func ReadSnapshotIndex(store MinimapStore, canvas string, ctx context.Context) (*SnapshotIndex, error) {
+	idx := &SnapshotIndex{Canvas: canvas, Snapshots: []Snapshot{}}
+	obj, err := store.Get(ctx, SnapshotIndexName(canvas))
+
+	if err == ErrNotFound {
+		return idx, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(obj.Data, idx); err != nil {
+		return nil, err
+	}
+
+	return idx, nil
+}
+
+// ArchiveMinimap keeps a published PNG minimap as a snapshot, unless the last
+// one is less than MinimapArchiveInterval old, and drops the snapshots past
+// MinimapArchiveRetention. It reports whether a snapshot was taken. Callers
+// hold the minimap lock of the canvas, which keeps the index single writer.
+func ArchiveMinimap(store MinimapStore, canvas string, data []byte, info MinimapInfo, ctx context.Context, cfg *ArchiveConfig) (bool, error) {
+	if cfg.MinimapArchiveRetention <= 0 {
+		return false, nil
+	}
+
+	idx, err := ReadSnapshotIndex(store, canvas, ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	takenAt := info.GeneratedAt.UTC()
+
+	if last := idx.Latest(); last != nil && takenAt.Sub(last.TakenAt) < cfg.MinimapArchiveInterval {
+		return false, nil
+	}
+
+	snapshot := Snapshot{
+		Id:      takenAt.Format(SNAPSHOT_ID_FORMAT),
+		TakenAt: takenAt,
+		Width:   info.Width,
+		Height:  info.Height,
+		ETag:    MinimapETag(data),
+	}
+
+	if err := store.Put(ctx, SnapshotName(canvas, snapshot.Id), "image/png", data); err != nil {
+		return false, err
+	}
+
+	// Two runs within a second share an id, the later one wins
+	idx.Snapshots = slices.DeleteFunc(idx.Snapshots, func(s Snapshot) bool {
+		return s.Id == snapshot.Id
+	})
+
+	idx.Snapshots = append(idx.Snapshots, snapshot)
+
+	cutoff := takenAt.Add(-cfg.MinimapArchiveRetention)
+	expired := slices.IndexFunc(idx.Snapshots, func(s Snapshot) bool {
+		return s.TakenAt.After(cutoff)
+	})
+
+	pruned := slices.Clone(idx.Snapshots[:expired])
+	idx.Snapshots = idx.Snapshots[expired:]
+
+	idxJson, err := json.Marshal(idx)
+
+	if err != nil {
+		return true, err
+	}
+
+	if err := store.Put(ctx, SnapshotIndexName(canvas), "application/json", idxJson); err != nil {
+		return true, err
+	}
+
+	// Dropped from the index first, a failed delete only leaves an orphan
+	for _, s := range pruned {
+		if err := store.Delete(ctx, SnapshotName(canvas, s.Id)); err != nil && err != ErrNotFound {
+			log.Printf("could not delete %s minimap snapshot %s: %v", canvas, s.Id, err)
+		}
+	}
+
+	return true, nil
+}
+
+// DIFF_DIM is the share of brightness kept by pages that did not change.
+const DIFF_DIM = 0.25
+
+// DiffRemoved marks pages whose presses were undone between two snapshots.
+var DiffRemoved = [3]byte{0xff, 0x00, 0xff}
+
+// RenderDiff draws to with every page that differs from from highlighted
+// over the dimmed others. Changed pages keep their new color at full
+// brightness, pages that lost their color are marked DiffRemoved. Pages
+// outside of from, after the canvas grew, count as changed when pressed.
+func RenderDiff(from image.Image, to image.Image) (*image.RGBA, int) {
+	bounds := to.Bounds()
+	diff := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	changed := 0
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := to.At(x, y).RGBA()
+			fr, fg, fb, fa := from.At(x, y).RGBA()
+			o := diff.PixOffset(x-bounds.Min.X, y-bounds.Min.Y)
+			pixel := diff.Pix[o : o+4]
+
+			switch {
+			case r == fr && g == fg && b == fb && a == fa:
+				if a > 0 {
+					pixel[0], pixel[1], pixel[2], pixel[3] = dim(r), dim(g), dim(b), 0xff
+				}
+			case a == 0:
+				changed++
+				pixel[0], pixel[1], pixel[2], pixel[3] = DiffRemoved[0], DiffRemoved[1], DiffRemoved[2], 0xff
+			default:
+				changed++
+				pixel[0], pixel[1], pixel[2], pixel[3] = byte(r>>8), byte(g>>8), byte(b>>8), 0xff
+			}
+		}
+	}
+
+	return diff, changed
+}
+
+func dim(c uint32) byte {
+	return byte(float64(c>>8) * DIFF_DIM)
+}
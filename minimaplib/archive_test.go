@@ -0,0 +1,118 @@
+package minimaplib
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestArchiveMinimapKeepsRollingSnapshots(t *testing.T) {
+	ctx := context.Background()
+	store := &LocalStore{Dir: t.TempDir()}
+	cfg := &ArchiveConfig{MinimapArchiveRetention: time.Hour, MinimapArchiveInterval: 10 * time.Minute}
+	start := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	archive := func(at time.Duration, data string) bool {
+		taken, err := ArchiveMinimap(store, "main", []byte(data), MinimapInfo{GeneratedAt: start.Add(at), Width: 2, Height: 1}, ctx, cfg)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		return taken
+	}
+
+	if !archive(0, "first") {
+		t.Fatal("expected the first minimap to be archived")
+	}
+
+	if archive(5*time.Minute, "too soon") {
+		t.Error("expected a minimap within the interval to be skipped")
+	}
+
+	archive(30*time.Minute, "second")
+	archive(70*time.Minute, "third")
+
+	idx, err := ReadSnapshotIndex(store, "main", ctx)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The first snapshot is past the retention at 01:10
+	if len(idx.Snapshots) != 2 || idx.Snapshots[0].Id != "20261019T003000Z" || idx.Latest().Id != "20261019T011000Z" {
+		t.Fatalf("unexpected snapshots %+v", idx.Snapshots)
+	}
+
+	if _, err := store.Get(ctx, SnapshotName("main", "20261019T000000Z")); err != ErrNotFound {
+		t.Errorf("expected the expired snapshot to be deleted, got %v", err)
+	}
+
+	obj, err := store.Get(ctx, SnapshotName("main", idx.Snapshots[0].Id))
+
+	if err != nil || string(obj.Data) != "second" || idx.Snapshots[0].ETag != MinimapETag([]byte("second")) {
+		t.Errorf("expected the second snapshot to be kept, got %v", err)
+	}
+
+	if s := idx.Before(start.Add(time.Hour)); s == nil || s.Id != "20261019T003000Z" {
+		t.Errorf("expected the snapshot before 01:00, got %+v", s)
+	}
+
+	if idx.Before(start) != nil || idx.Find("nope") != nil {
+		t.Error("expected no snapshot")
+	}
+
+	if taken, _ := ArchiveMinimap(store, "main", []byte("x"), MinimapInfo{GeneratedAt: start.Add(2 * time.Hour)}, ctx, &ArchiveConfig{}); taken {
+		t.Error("expected no snapshots without a retention")
+	}
+}
+
+func TestReadSnapshotIndexOfNewCanvas(t *testing.T) {
+	idx, err := ReadSnapshotIndex(&LocalStore{Dir: t.TempDir()}, "fresh", context.Background())
+
+	if err != nil || idx.Canvas != "fresh" || idx.Snapshots == nil || idx.Latest() != nil {
+		t.Errorf("expected an empty index, got %+v (%v)", idx, err)
+	}
+}
+
+func TestRenderDiff(t *testing.T) {
+	red := color.RGBA{0xff, 0x00, 0x00, 0xff}
+	blue := color.RGBA{0x00, 0x00, 0xff, 0xff}
+
+	from := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	from.SetRGBA(0, 0, red)
+	from.SetRGBA(1, 0, red)
+
+	// The canvas grew by a column
+	to := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	to.SetRGBA(0, 0, red)
+	to.SetRGBA(2, 0, blue)
+	to.SetRGBA(3, 0, blue)
+
+	diff, changed := RenderDiff(from, to)
+
+	if changed != 3 {
+		t.Errorf("expected 3 changed pages, got %d", changed)
+	}
+
+	expected := []color.RGBA{
+		{0x3f, 0x00, 0x00, 0xff},
+		{DiffRemoved[0], DiffRemoved[1], DiffRemoved[2], 0xff},
+		blue,
+		blue,
+	}
+
+	for x, c := range expected {
+		if got := diff.RGBAAt(x, 0); got != c {
+			t.Errorf("page %d: expected %v, got %v", x, c, got)
+		}
+	}
+
+	empty := image.NewRGBA(image.Rect(0, 0, 1, 1))
+
+	if diff, changed := RenderDiff(empty, empty); changed != 0 || diff.RGBAAt(0, 0).A != 0 {
+		t.Error("expected pages unpressed in both snapshots to stay transparent")
+	}
+}
@@ -0,0 +1,116 @@
+package minimaplib
+
+import (
+	"fmt"
+)
+
+// MinimapColorMode is how the buttons of a page are reduced to the single
+// pixel drawn for it on the minimap.
+type MinimapColorMode string
+
+const (
+	// Mean of the pressed buttons, unpressed buttons do not darken the page
+	MINIMAP_COLOR_MEAN MinimapColorMode = "mean"
+
+	// The most pressed color, ties go to the color that reaches the count first
+	// scanning the page in button order
+	MINIMAP_COLOR_DOMINANT MinimapColorMode = "dominant"
+
+	// Gray that brightens as more of the page is pressed
+	MINIMAP_COLOR_DENSITY MinimapColorMode = "density"
+)
+
+// Decode lets envconfig reject unknown modes when the config is loaded.
+func (m *MinimapColorMode) Decode(value string) error {
+	mode := MinimapColorMode(value)
+
+	switch mode {
+	case MINIMAP_COLOR_MEAN, MINIMAP_COLOR_DOMINANT, MINIMAP_COLOR_DENSITY:
+		*m = mode
+		return nil
+	}
+
+	return fmt.Errorf("unknown minimap color mode %q", value)
+}
+
+// PageColor reduces the raw buttons of a page to one color. Pages without a
+// pressed button have no color.
+func PageColor(mode MinimapColorMode, buttons []byte) ([]byte, bool) {
+	switch mode {
+	case MINIMAP_COLOR_DOMINANT:
+		return dominantPressedColor(buttons)
+	case MINIMAP_COLOR_DENSITY:
+		return pressedDensityColor(buttons)
+	default:
+		return meanPressedColor(buttons)
+	}
+}
+
+// IsPressed reports whether a button has a color. Unpressed buttons are 000000.
+func IsPressed(rgb []byte) bool {
+	return rgb[0] != 0 || rgb[1] != 0 || rgb[2] != 0
+}
+
+// meanPressedColor averages the pressed buttons of a page, ignoring the
+// unpressed ones.
+func meanPressedColor(buttons []byte) ([]byte, bool) {
+	var r, g, b, n int
+
+	for i := 0; i+2 < len(buttons); i += 3 {
+		if !IsPressed(buttons[i : i+3]) {
+			continue
+		}
+
+		r, g, b, n = r+int(buttons[i]), g+int(buttons[i+1]), b+int(buttons[i+2]), n+1
+	}
+
+	if n == 0 {
+		return nil, false
+	}
+
+	return []byte{byte(r / n), byte(g / n), byte(b / n)}, true
+}
+
+func dominantPressedColor(buttons []byte) ([]byte, bool) {
+	counts := make(map[[3]byte]int)
+	var dominant [3]byte
+	best := 0
+
+	for i := 0; i+2 < len(buttons); i += 3 {
+		if !IsPressed(buttons[i : i+3]) {
+			continue
+		}
+
+		rgb := [3]byte(buttons[i : i+3])
+		counts[rgb]++
+
+		if counts[rgb] > best {
+			dominant, best = rgb, counts[rgb]
+		}
+	}
+
+	if best == 0 {
+		return nil, false
+	}
+
+	return dominant[:], true
+}
+
+// pressedDensityColor rounds up, so a single press on a large page still
+// shows.
+func pressedDensityColor(buttons []byte) ([]byte, bool) {
+	total, n := len(buttons)/3, 0
+
+	for i := 0; i+2 < len(buttons); i += 3 {
+		if IsPressed(buttons[i : i+3]) {
+			n++
+		}
+	}
+
+	if n == 0 {
+		return nil, false
+	}
+
+	v := byte((255*n + total - 1) / total)
+	return []byte{v, v, v}, true
+}
@@ -0,0 +1,48 @@
+package minimaplib
+
+import (
+	"testing"
+)
+
+func TestPageColorModes(t *testing.T) {
+	// Two red buttons, one blue and one unpressed
+	buttons := []byte{
+		0xff, 0x00, 0x00,
+		0x00, 0x00, 0x00,
+		0x00, 0x00, 0xff,
+		0xff, 0x00, 0x00,
+	}
+
+	cases := []struct {
+		mode     MinimapColorMode
+		expected []byte
+	}{
+		{MINIMAP_COLOR_MEAN, []byte{0xaa, 0x00, 0x55}},
+		{MINIMAP_COLOR_DOMINANT, []byte{0xff, 0x00, 0x00}},
+		{MINIMAP_COLOR_DENSITY, []byte{0xc0, 0xc0, 0xc0}},
+	}
+
+	for _, tc := range cases {
+		rgb, ok := PageColor(tc.mode, buttons)
+
+		if !ok || string(rgb) != string(tc.expected) {
+			t.Errorf("%s: expected %x, got %x (%v)", tc.mode, tc.expected, rgb, ok)
+		}
+
+		if _, ok := PageColor(tc.mode, make([]byte, len(buttons))); ok {
+			t.Errorf("%s: expected an unpressed page to have no color", tc.mode)
+		}
+	}
+}
+
+func TestMinimapColorModeDecode(t *testing.T) {
+	var mode MinimapColorMode
+
+	if err := mode.Decode("dominant"); err != nil || mode != MINIMAP_COLOR_DOMINANT {
+		t.Errorf("expected dominant, got %q (%v)", mode, err)
+	}
+
+	if err := mode.Decode("median"); err == nil {
+		t.Error("expected an unknown mode to be rejected")
+	}
+}
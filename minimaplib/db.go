@@ -0,0 +1,123 @@
+package minimaplib
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/cmcquillan/one-billion-buttons/dblib"
+)
+
+// MinimapItem is the raw state of one page. Coordinates are 1-based.
+type MinimapItem struct {
+	X       int64
+	Y       int64
+	Buttons []byte
+	Seq     int64
+}
+
+// CanvasSize is the page grid of a canvas, one minimap pixel per page.
+type CanvasSize struct {
+	Name     string
+	Cols     int64
+	Rows     int64
+	ReadOnly bool
+}
+
+// PageDb reads the pages a minimap is drawn from.
+type PageDb interface {
+	ReadPageShard(canvas string, xFrom int64, xTo int64, ctx context.Context, page func(item *MinimapItem)) error
+	ReadChangedPages(canvas string, since int64, ctx context.Context, page func(item *MinimapItem)) error
+}
+
+type MinimapDbSql struct {
+	ConnStr string
+}
+
+func (db *MinimapDbSql) GetConnectionString() string {
+	return db.ConnStr
+}
+
+// ReadCanvases reads the size of every canvas.
+func (db *MinimapDbSql) ReadCanvases(ctx context.Context) ([]CanvasSize, error) {
+	canvases := []CanvasSize{}
+
+	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
+		rows, err := dbc.QueryContext(ctx, "select name, cols, rows, read_only from canvas order by name")
+
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			canvas := CanvasSize{}
+
+			if err := rows.Scan(&canvas.Name, &canvas.Cols, &canvas.Rows, &canvas.ReadOnly); err != nil {
+				return err
+			}
+
+			canvases = append(canvases, canvas)
+		}
+
+		return rows.Err()
+	})
+
+	return canvases, err
+}
+
+// ReadPageShard reads the raw buttons of every stored page in columns xFrom
+// to xTo of a canvas. Shards walk the primary key, so they can be read side
+// by side over their own connections.
+func (db *MinimapDbSql) ReadPageShard(canvas string, xFrom int64, xTo int64, ctx context.Context, page func(item *MinimapItem)) error {
+	return db.readPages(ctx, page, `
+		select x_coord, y_coord, buttons, change_seq
+		from button
+		where canvas = $1 and x_coord between $2 and $3`, canvas, xFrom, xTo)
+}
+
+// ReadChangedPages reads the raw buttons of every page of a canvas written
+// after change sequence since.
+func (db *MinimapDbSql) ReadChangedPages(canvas string, since int64, ctx context.Context, page func(item *MinimapItem)) error {
+	return db.readPages(ctx, page, `
+		select x_coord, y_coord, buttons, change_seq
+		from button
+		where canvas = $1 and change_seq > $2`, canvas, since)
+}
+
+// ReadPageRows reads the raw buttons of every stored page in rows yFrom to
+// yTo of a canvas.
+func (db *MinimapDbSql) ReadPageRows(canvas string, yFrom int64, yTo int64, ctx context.Context, page func(x int64, y int64, buttons []byte)) error {
+	return db.readPages(ctx, func(item *MinimapItem) {
+		page(item.X, item.Y, item.Buttons)
+	}, `
+		select x_coord, y_coord, buttons, change_seq
+		from button
+		where canvas = $1 and y_coord between $2 and $3`, canvas, yFrom, yTo)
+}
+
+func (db *MinimapDbSql) readPages(ctx context.Context, page func(item *MinimapItem), query string, args ...any) error {
+	err := dblib.OpenConnAndExec(db, func(dbc *sql.DB) error {
+		rows, err := dbc.QueryContext(ctx, query, args...)
+
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			item := MinimapItem{}
+
+			if err := rows.Scan(&item.X, &item.Y, &item.Buttons, &item.Seq); err != nil {
+				return err
+			}
+
+			page(&item)
+		}
+
+		return rows.Err()
+	})
+
+	return err
+}
@@ -0,0 +1,40 @@
+package minimaplib
+
+import "context"
+
+// MemoryPageDb serves pages from memory instead of the button table. It
+// stands in for the database when testing the minimap pipeline, here and in
+// the app.
+type MemoryPageDb struct {
+	Items []*MinimapItem
+}
+
+func (db *MemoryPageDb) ReadPageShard(canvas string, xFrom int64, xTo int64, ctx context.Context, page func(item *MinimapItem)) error {
+	for _, item := range db.Items {
+		if item.X >= xFrom && item.X <= xTo {
+			page(item)
+		}
+	}
+
+	return nil
+}
+
+func (db *MemoryPageDb) ReadChangedPages(canvas string, since int64, ctx context.Context, page func(item *MinimapItem)) error {
+	for _, item := range db.Items {
+		if item.Seq > since {
+			page(item)
+		}
+	}
+
+	return nil
+}
+
+func (db *MemoryPageDb) ReadPageRows(canvas string, yFrom int64, yTo int64, ctx context.Context, page func(x int64, y int64, buttons []byte)) error {
+	for _, item := range db.Items {
+		if item.Y >= yFrom && item.Y <= yTo {
+			page(item.X, item.Y, item.Buttons)
+		}
+	}
+
+	return nil
+}
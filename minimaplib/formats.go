@@ -0,0 +1,152 @@
+package minimaplib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"slices"
+)
+
+// MinimapFormat is a variant of the minimap, named by its extension.
+type MinimapFormat string
+
+const (
+	MINIMAP_PNG  MinimapFormat = "png"
+	MINIMAP_JPEG MinimapFormat = "jpg"
+	MINIMAP_GIF  MinimapFormat = "gif"
+	MINIMAP_JSON MinimapFormat = "json"
+	MINIMAP_BIN  MinimapFormat = "bin"
+)
+
+// MINIMAP_BINARY_HEADER_BYTES is the big-endian uint32 width and height in
+// front of the raw colors of the binary minimap.
+const MINIMAP_BINARY_HEADER_BYTES = 8
+
+var minimapContentTypes = map[MinimapFormat]string{
+	MINIMAP_PNG:  "image/png",
+	MINIMAP_JPEG: "image/jpeg",
+	MINIMAP_GIF:  "image/gif",
+	MINIMAP_JSON: "application/json",
+	MINIMAP_BIN:  "application/octet-stream",
+}
+
+func (f MinimapFormat) ContentType() string {
+	return minimapContentTypes[f]
+}
+
+// Decode lets envconfig reject unknown formats when the config is loaded.
+func (f *MinimapFormat) Decode(value string) error {
+	format := MinimapFormat(value)
+
+	if format == "jpeg" {
+		format = MINIMAP_JPEG
+	}
+
+	if _, ok := minimapContentTypes[format]; !ok {
+		return fmt.Errorf("unknown minimap format %q", value)
+	}
+
+	*f = format
+	return nil
+}
+
+// DrawnFormats are the configured formats. PNG is always drawn, the tiles,
+// the function and archived seasons rely on it.
+func (cfg *RenderConfig) DrawnFormats() []MinimapFormat {
+	formats := []MinimapFormat{MINIMAP_PNG}
+
+	for _, f := range cfg.MinimapFormats {
+		if !slices.Contains(formats, f) {
+			formats = append(formats, f)
+		}
+	}
+
+	return formats
+}
+
+// MinimapJson is the JSON dump of the minimap. Pixels holds 6 hex digits per
+// page, row by row, and 000000 for pages without a pressed button.
+type MinimapJson struct {
+	Width  int64  `json:"width"`
+	Height int64  `json:"height"`
+	Pixels string `json:"pixels"`
+}
+
+// EncodeMinimap encodes one drawn minimap into every requested format.
+func EncodeMinimap(img *image.RGBA, formats []MinimapFormat) (map[MinimapFormat][]byte, error) {
+	variants := make(map[MinimapFormat][]byte, len(formats))
+
+	for _, format := range formats {
+		buf := bytes.Buffer{}
+		var err error
+
+		switch format {
+		case MINIMAP_PNG:
+			// Redrawn every few seconds, best compression costs more than it saves
+			e := png.Encoder{CompressionLevel: png.DefaultCompression}
+			err = e.Encode(&buf, img)
+		case MINIMAP_JPEG:
+			// Without alpha, pages without a pressed button are black
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+		case MINIMAP_GIF:
+			err = gif.Encode(&buf, toPaletted(img), nil)
+		case MINIMAP_JSON:
+			err = json.NewEncoder(&buf).Encode(MinimapJson{
+				Width:  int64(img.Rect.Dx()),
+				Height: int64(img.Rect.Dy()),
+				Pixels: hex.EncodeToString(minimapRgb(img)),
+			})
+		case MINIMAP_BIN:
+			header := make([]byte, MINIMAP_BINARY_HEADER_BYTES)
+			binary.BigEndian.PutUint32(header[0:], uint32(img.Rect.Dx()))
+			binary.BigEndian.PutUint32(header[4:], uint32(img.Rect.Dy()))
+			buf.Write(header)
+			buf.Write(minimapRgb(img))
+		default:
+			err = fmt.Errorf("unknown minimap format %q", format)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		variants[format] = buf.Bytes()
+	}
+
+	return variants, nil
+}
+
+// minimapRgb drops the alpha channel. Transparent pixels are already 000000.
+func minimapRgb(img *image.RGBA) []byte {
+	rgb := make([]byte, 0, img.Rect.Dx()*img.Rect.Dy()*3)
+
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, y):img.PixOffset(img.Rect.Max.X, y)]
+
+		for i := 0; i < len(row); i += 4 {
+			rgb = append(rgb, row[i], row[i+1], row[i+2])
+		}
+	}
+
+	return rgb
+}
+
+// toPaletted maps every page to the closest web safe color without
+// dithering, which would smear single pages, and keeps a transparent entry
+// for pages without a pressed button.
+func toPaletted(img *image.RGBA) *image.Paletted {
+	colors := append(color.Palette{color.Transparent}, palette.WebSafe...)
+	paletted := image.NewPaletted(img.Rect, colors)
+
+	draw.Draw(paletted, img.Rect, img, img.Rect.Min, draw.Src)
+	return paletted
+}
@@ -0,0 +1,84 @@
+package minimaplib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"testing"
+)
+
+func TestEncodeMinimap(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(1, 0, color.RGBA{0xff, 0x00, 0x33, 0xff})
+
+	variants, err := EncodeMinimap(img, []MinimapFormat{MINIMAP_PNG, MINIMAP_JPEG, MINIMAP_GIF, MINIMAP_JSON, MINIMAP_BIN})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bin := variants[MINIMAP_BIN]
+
+	if binary.BigEndian.Uint32(bin[0:]) != 2 || binary.BigEndian.Uint32(bin[4:]) != 1 {
+		t.Errorf("unexpected binary header %x", bin[:MINIMAP_BINARY_HEADER_BYTES])
+	}
+
+	if !bytes.Equal(bin[MINIMAP_BINARY_HEADER_BYTES:], []byte{0, 0, 0, 0xff, 0x00, 0x33}) {
+		t.Errorf("unexpected binary colors %x", bin[MINIMAP_BINARY_HEADER_BYTES:])
+	}
+
+	var dump MinimapJson
+
+	if err := json.Unmarshal(variants[MINIMAP_JSON], &dump); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if dump.Width != 2 || dump.Height != 1 || dump.Pixels != "000000ff0033" {
+		t.Errorf("unexpected json dump %+v", dump)
+	}
+
+	paletted, err := gif.Decode(bytes.NewReader(variants[MINIMAP_GIF]))
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, _, a := paletted.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("expected pages without presses to stay transparent in the gif")
+	}
+
+	if r, g, b, _ := paletted.At(1, 0).RGBA(); r>>8 != 0xff || g>>8 != 0x00 || b>>8 != 0x33 {
+		t.Errorf("expected the web safe color, got %x %x %x", r>>8, g>>8, b>>8)
+	}
+
+	if _, err := jpeg.Decode(bytes.NewReader(variants[MINIMAP_JPEG])); err != nil {
+		t.Errorf("could not decode jpeg: %v", err)
+	}
+
+	if _, err := EncodeMinimap(img, []MinimapFormat{"webp"}); err == nil {
+		t.Errorf("expected unknown formats to fail")
+	}
+}
+
+func TestMinimapFormatsStartWithPng(t *testing.T) {
+	cfg := &RenderConfig{MinimapFormats: []MinimapFormat{MINIMAP_JSON, MINIMAP_PNG, MINIMAP_JSON}}
+	formats := cfg.DrawnFormats()
+
+	if len(formats) != 2 || formats[0] != MINIMAP_PNG || formats[1] != MINIMAP_JSON {
+		t.Errorf("unexpected formats %v", formats)
+	}
+
+	var f MinimapFormat
+
+	if err := f.Decode("jpeg"); err != nil || f != MINIMAP_JPEG {
+		t.Errorf("expected jpeg to mean jpg, got %q (%v)", f, err)
+	}
+
+	if err := f.Decode("webp"); err == nil {
+		t.Errorf("expected unknown formats to be rejected")
+	}
+}
@@ -0,0 +1,104 @@
+package minimaplib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"slices"
+	"time"
+)
+
+// DEFAULT_CANVAS keeps the original object names of the minimap.
+const DEFAULT_CANVAS = "main"
+
+const MINIMAP_LOCK_TYPE = "minimap_gen"
+
+// MinimapLockType is the lock held while drawing the minimap of a canvas, by
+// the app and the function alike.
+func MinimapLockType(canvas string) string {
+	return MINIMAP_LOCK_TYPE + ":" + canvas
+}
+
+// MinimapName is the store object of the minimap of a canvas in a format.
+func MinimapName(canvas string, format MinimapFormat) string {
+	if canvas == DEFAULT_CANVAS {
+		return "minimap." + string(format)
+	}
+
+	return "minimap-" + canvas + "." + string(format)
+}
+
+// MinimapInfoName is the store object holding the MinimapInfo of a canvas,
+// next to its minimap.
+func MinimapInfoName(canvas string) string {
+	if canvas == DEFAULT_CANVAS {
+		return "minimap.info.json"
+	}
+
+	return "minimap-" + canvas + ".info.json"
+}
+
+// MinimapInfo describes the run that drew the current minimap of a canvas.
+// ETag is the one of the PNG.
+type MinimapInfo struct {
+	Canvas       string          `json:"canvas"`
+	GeneratedAt  time.Time       `json:"generated_at"`
+	DurationMs   int64           `json:"duration_ms"`
+	Width        int64           `json:"width"`
+	Height       int64           `json:"height"`
+	PagesScanned int64           `json:"pages_scanned"`
+	Full         bool            `json:"full"`
+	Formats      []MinimapFormat `json:"formats"`
+	ETag         string          `json:"etag"`
+}
+
+func MinimapETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Publish puts freshly encoded minimaps and their info into the store and
+// returns the info as stored. The PNG goes last of the images, as its
+// presence marks a minimap as drawn.
+func Publish(store MinimapStore, canvas string, variants map[MinimapFormat][]byte, info MinimapInfo, ctx context.Context) (MinimapInfo, error) {
+	info.Canvas = canvas
+	info.ETag = MinimapETag(variants[MINIMAP_PNG])
+	info.Formats = slices.Sorted(maps.Keys(variants))
+
+	formats := slices.DeleteFunc(slices.Clone(info.Formats), func(f MinimapFormat) bool {
+		return f == MINIMAP_PNG
+	})
+
+	for _, format := range append(formats, MINIMAP_PNG) {
+		if err := store.Put(ctx, MinimapName(canvas, format), format.ContentType(), variants[format]); err != nil {
+			return info, err
+		}
+	}
+
+	infoJson, err := json.Marshal(info)
+
+	if err != nil {
+		return info, err
+	}
+
+	return info, store.Put(ctx, MinimapInfoName(canvas), "application/json", infoJson)
+}
+
+// ReadMinimapInfo reads the info of the last published minimap of a canvas.
+func ReadMinimapInfo(store MinimapStore, canvas string, ctx context.Context) (*MinimapInfo, error) {
+	obj, err := store.Get(ctx, MinimapInfoName(canvas))
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := &MinimapInfo{}
+
+	if err := json.Unmarshal(obj.Data, info); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
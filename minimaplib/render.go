@@ -0,0 +1,180 @@
+package minimaplib
+
+import (
+	"context"
+	"image"
+	"log"
+	"sync"
+	"time"
+)
+
+// RenderConfig is how minimaps are drawn and encoded.
+type RenderConfig struct {
+	// How a page is reduced to one minimap pixel: mean, dominant or density
+	MinimapColorMode MinimapColorMode `envconfig:"MINIMAP_COLOR_MODE" default:"mean"`
+
+	// Formats drawn besides the PNG: jpg, gif, json and bin
+	MinimapFormats []MinimapFormat `envconfig:"MINIMAP_FORMATS" default:"png,jpg,gif,json,bin"`
+
+	// Full minimaps read column shards of the canvas on this many connections at once
+	MinimapWorkers   int   `envconfig:"MINIMAP_WORKERS" default:"4"`
+	MinimapShardCols int64 `envconfig:"MINIMAP_SHARD_COLS" default:"256"`
+}
+
+// Minimap is a drawn minimap, one pixel per page. It can be kept between
+// runs so a run only has to draw the pages written since the last one.
+type Minimap struct {
+	Image   *image.RGBA
+	Cols    int64
+	Rows    int64
+	Mode    MinimapColorMode
+	LastSeq int64
+	FullAt  time.Time
+}
+
+func NewMinimap(cols int64, rows int64, mode MinimapColorMode) *Minimap {
+	return &Minimap{
+		Image:  image.NewRGBA(image.Rect(0, 0, int(cols), int(rows))),
+		Cols:   cols,
+		Rows:   rows,
+		Mode:   mode,
+		FullAt: time.Now(),
+	}
+}
+
+// Draw paints one page and reports whether its pixel changed.
+func (m *Minimap) Draw(item *MinimapItem) bool {
+	if item.Seq > m.LastSeq {
+		m.LastSeq = item.Seq
+	}
+
+	return m.Paint(item)
+}
+
+// Paint sets the pixel of one page straight in the pixel buffer. Pages of
+// different columns never share bytes, so shards paint concurrently.
+func (m *Minimap) Paint(item *MinimapItem) bool {
+	if item.X < 1 || item.Y < 1 || item.X > m.Cols || item.Y > m.Rows {
+		return false
+	}
+
+	o := m.Image.PixOffset(int(item.X)-1, int(item.Y)-1)
+	pixel := m.Image.Pix[o : o+4]
+	before := [4]byte(pixel)
+
+	if rgb, ok := PageColor(m.Mode, item.Buttons); ok {
+		pixel[0], pixel[1], pixel[2], pixel[3] = rgb[0], rgb[1], rgb[2], 0xff
+	} else {
+		clear(pixel)
+	}
+
+	return before != [4]byte(pixel)
+}
+
+// minimapShards splits cols columns into shards of at most MinimapShardCols
+// columns.
+func minimapShards(cols int64, cfg *RenderConfig) [][2]int64 {
+	width := max(cfg.MinimapShardCols, 1)
+	shards := make([][2]int64, 0, cols/width+1)
+
+	for x := int64(1); x <= cols; x += width {
+		shards = append(shards, [2]int64{x, min(x+width-1, cols)})
+	}
+
+	return shards
+}
+
+// DrawFullMinimap draws every page of a canvas, reading column shards on
+// MinimapWorkers connections at once. The first failing shard cancels the
+// others. It also returns the number of pages read.
+func DrawFullMinimap(db PageDb, canvas string, cols int64, rows int64, ctx context.Context, cfg *RenderConfig) (*Minimap, int64, error) {
+	m := NewMinimap(cols, rows, cfg.MinimapColorMode)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	shards := make(chan [2]int64)
+	wg := sync.WaitGroup{}
+	mu := sync.Mutex{}
+	scanned := int64(0)
+	var firstErr error
+
+	for range max(cfg.MinimapWorkers, 1) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for shard := range shards {
+				read, lastSeq := int64(0), int64(0)
+
+				err := db.ReadPageShard(canvas, shard[0], shard[1], ctx, func(item *MinimapItem) {
+					m.Paint(item)
+					read++
+					lastSeq = max(lastSeq, item.Seq)
+				})
+
+				mu.Lock()
+				scanned += read
+				m.LastSeq = max(m.LastSeq, lastSeq)
+
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, shard := range minimapShards(cols, cfg) {
+		select {
+		case shards <- shard:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(shards)
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+
+	if firstErr != nil {
+		return nil, scanned, firstErr
+	}
+
+	log.Printf("scanned %d pages for %s minimap", scanned, canvas)
+	return m, scanned, nil
+}
+
+// DrawChangedPages draws the pages written since the last run and reports
+// whether any pixel changed. A page write takes its change_seq before it
+// commits, so a slow writer can commit below the highest change_seq already
+// drawn. The last overlap sequence values are read again to pick those up.
+func (m *Minimap) DrawChangedPages(db PageDb, canvas string, overlap int64, ctx context.Context) (bool, int64, error) {
+	since := m.LastSeq - overlap
+	read, changed := int64(0), 0
+
+	err := db.ReadChangedPages(canvas, since, ctx, func(item *MinimapItem) {
+		read++
+
+		if m.Draw(item) {
+			changed++
+		}
+	})
+
+	if err != nil {
+		return false, read, err
+	}
+
+	if changed > 0 {
+		log.Printf("redrew %d of %d changed pages for %s minimap", changed, read, canvas)
+	}
+
+	return changed > 0, read, nil
+}
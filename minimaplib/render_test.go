@@ -0,0 +1,83 @@
+package minimaplib
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// onePressPage is a page of 4 buttons with only the first one set.
+func onePressPage(rgb []byte) []byte {
+	return append(rgb, make([]byte, 9)...)
+}
+
+func TestMinimapDrawsUnpressedTransparent(t *testing.T) {
+	m := NewMinimap(2, 1, MINIMAP_COLOR_MEAN)
+
+	if !m.Draw(&MinimapItem{X: 2, Y: 1, Buttons: onePressPage([]byte{0x80, 0x80, 0}), Seq: 3}) {
+		t.Fatal("expected a pressed page to change the minimap")
+	}
+
+	if c := m.Image.RGBAAt(1, 0); c.A != 0xff || m.LastSeq != 3 {
+		t.Errorf("expected a pressed page to be opaque at change 3, got %v at %d", c, m.LastSeq)
+	}
+
+	if m.Draw(&MinimapItem{X: 3, Y: 1, Buttons: onePressPage([]byte{1, 1, 1})}) {
+		t.Error("expected a page outside the grid to be ignored")
+	}
+
+	if !m.Draw(&MinimapItem{X: 2, Y: 1, Buttons: onePressPage([]byte{0, 0, 0})}) || m.Image.RGBAAt(1, 0).A != 0 {
+		t.Error("expected an unpressed page to be transparent")
+	}
+}
+
+func TestMinimapShardsCoverEveryColumn(t *testing.T) {
+	shards := minimapShards(7, &RenderConfig{MinimapShardCols: 3})
+
+	if len(shards) != 3 || shards[0] != [2]int64{1, 3} || shards[1] != [2]int64{4, 6} || shards[2] != [2]int64{7, 7} {
+		t.Errorf("unexpected shards %v", shards)
+	}
+}
+
+type failingShardDb struct {
+	MemoryPageDb
+}
+
+func (db *failingShardDb) ReadPageShard(canvas string, xFrom int64, xTo int64, ctx context.Context, page func(item *MinimapItem)) error {
+	if xFrom > 1 {
+		return errors.New("connection lost")
+	}
+
+	return db.MemoryPageDb.ReadPageShard(canvas, xFrom, xTo, ctx, page)
+}
+
+func TestDrawFullMinimapInShards(t *testing.T) {
+	cfg := &RenderConfig{MinimapWorkers: 3, MinimapShardCols: 2, MinimapColorMode: MINIMAP_COLOR_MEAN}
+	cols, rows := int64(9), int64(3)
+	db := &MemoryPageDb{}
+
+	for x := int64(1); x <= cols; x++ {
+		db.Items = append(db.Items, &MinimapItem{X: x, Y: x%rows + 1, Buttons: onePressPage([]byte{byte(x), 0, 0}), Seq: x * 2})
+	}
+
+	m, scanned, err := DrawFullMinimap(db, "main", cols, rows, context.Background(), cfg)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if scanned != cols || m.LastSeq != cols*2 {
+		t.Errorf("expected %d pages up to change %d, got %d up to %d", cols, cols*2, scanned, m.LastSeq)
+	}
+
+	// Pages land on 0-based pixels
+	for _, item := range db.Items {
+		if c := m.Image.RGBAAt(int(item.X)-1, int(item.Y)-1); c.R != byte(item.X) || c.A != 0xff {
+			t.Errorf("page %d,%d was drawn as %v", item.X, item.Y, c)
+		}
+	}
+
+	if _, _, err := DrawFullMinimap(&failingShardDb{*db}, "main", cols, rows, context.Background(), cfg); err == nil {
+		t.Error("expected a failing shard to fail the minimap")
+	}
+}
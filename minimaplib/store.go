@@ -0,0 +1,68 @@
+package minimaplib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrNotFound = errors.New("minimap object not found")
+var ErrNotModified = errors.New("minimap object not modified")
+
+// MinimapStore is where rendered minimaps are published and read back. Names
+// are slash separated, like minimap.png or tiles/main/0/0/0.png. A Put is
+// atomic: readers see either the previous object or the new one.
+// GetIfNoneMatch returns ErrNotModified instead of reading an object that
+// still has the ETag etag, an empty etag always reads it.
+type MinimapStore interface {
+	Put(ctx context.Context, name string, contentType string, data []byte) error
+	Get(ctx context.Context, name string) (*StoredObject, error)
+	GetIfNoneMatch(ctx context.Context, name string, etag string) (*StoredObject, error)
+	Delete(ctx context.Context, name string) error
+}
+
+type StoredObject struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time
+
+	// Identifies this version of the object in the store
+	ETag string
+}
+
+// StoreConfig selects and configures a MinimapStore.
+type StoreConfig struct {
+	Store    string `envconfig:"MINIMAP_STORE" default:"local"`
+	StoreDir string `envconfig:"MINIMAP_STORE_DIR" default:"./static"`
+
+	// Any S3 compatible endpoint, objects are addressed path style
+	S3Endpoint  string `envconfig:"MINIMAP_S3_ENDPOINT" default:""`
+	S3Region    string `envconfig:"MINIMAP_S3_REGION" default:"us-east-1"`
+	S3Bucket    string `envconfig:"MINIMAP_S3_BUCKET" default:""`
+	S3Prefix    string `envconfig:"MINIMAP_S3_PREFIX" default:""`
+	S3AccessKey string `envconfig:"MINIMAP_S3_ACCESS_KEY" default:""`
+	S3SecretKey string `envconfig:"MINIMAP_S3_SECRET_KEY" default:""`
+}
+
+func NewStore(cfg *StoreConfig) (MinimapStore, error) {
+	switch cfg.Store {
+	case "local":
+		return &LocalStore{Dir: cfg.StoreDir}, nil
+	case "s3":
+		if len(cfg.S3Endpoint) == 0 || len(cfg.S3Bucket) == 0 {
+			return nil, errors.New("the s3 minimap store requires MINIMAP_S3_ENDPOINT and MINIMAP_S3_BUCKET")
+		}
+
+		return &S3Store{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			Prefix:    cfg.S3Prefix,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown minimap store %q", cfg.Store)
+}
@@ -0,0 +1,123 @@
+package minimaplib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore keeps objects as files under Dir.
+type LocalStore struct {
+	Dir string
+}
+
+func (s *LocalStore) path(name string) (string, error) {
+	clean := path.Clean("/" + name)
+
+	if clean == "/" || strings.Contains(name, "\\") {
+		return "", errors.New("invalid minimap object name " + name)
+	}
+
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
+
+// Put writes into a temporary file next to the object and renames it over the
+// object, so readers never see a partial file.
+func (s *LocalStore) Put(ctx context.Context, name string, contentType string, data []byte) error {
+	p, err := s.path(name)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".minimap-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *LocalStore) Get(ctx context.Context, name string) (*StoredObject, error) {
+	return s.GetIfNoneMatch(ctx, name, "")
+}
+
+// GetIfNoneMatch tags files by modification time and size. Every Put renames a
+// new file over the object, so a rewritten object gets a new tag.
+func (s *LocalStore) GetIfNoneMatch(ctx context.Context, name string, etag string) (*StoredObject, error) {
+	p, err := s.path(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(p)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	tag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+
+	if len(etag) > 0 && etag == tag {
+		return nil, ErrNotModified
+	}
+
+	data, err := os.ReadFile(p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoredObject{
+		Data:        data,
+		ContentType: mime.TypeByExtension(path.Ext(name)),
+		ModTime:     info.ModTime(),
+		ETag:        tag,
+	}, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, name string) error {
+	p, err := s.path(name)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
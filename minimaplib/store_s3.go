@@ -0,0 +1,269 @@
+package minimaplib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// S3Store keeps objects in a bucket of any S3 compatible service. Requests
+// are signed with AWS signature version 4 and address the bucket path style,
+// Endpoint/Bucket/Prefix+name, which every compatible service accepts.
+type S3Store struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+func (s *S3Store) objectUrl(name string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path += "/" + s.Bucket + "/" + s.Prefix + name
+	return u, nil
+}
+
+func (s *S3Store) do(ctx context.Context, method string, name string, header http.Header, body []byte) (*http.Response, error) {
+	u, err := s.objectUrl(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Sent exactly as signed
+	req.URL.RawPath = uriEncode(req.URL.Path, false)
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	signV4(req, body, s.Region, s.AccessKey, s.SecretKey, time.Now())
+
+	client := s.Client
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return client.Do(req)
+}
+
+// Put uploads an object. S3 replaces objects atomically, so no temporary
+// object is needed.
+func (s *S3Store) Put(ctx context.Context, name string, contentType string, data []byte) error {
+	header := http.Header{}
+
+	if len(contentType) > 0 {
+		header.Set("Content-Type", contentType)
+	}
+
+	res, err := s.do(ctx, http.MethodPut, name, header, data)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	return s3Error(res, http.StatusOK)
+}
+
+func (s *S3Store) Get(ctx context.Context, name string) (*StoredObject, error) {
+	return s.GetIfNoneMatch(ctx, name, "")
+}
+
+func (s *S3Store) GetIfNoneMatch(ctx context.Context, name string, etag string) (*StoredObject, error) {
+	header := http.Header{}
+
+	if len(etag) > 0 {
+		header.Set("If-None-Match", etag)
+	}
+
+	res, err := s.do(ctx, http.MethodGet, name, header, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		return nil, ErrNotModified
+	}
+
+	if err := s3Error(res, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(res.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &StoredObject{
+		Data:        data,
+		ContentType: res.Header.Get("Content-Type"),
+		ETag:        res.Header.Get("ETag"),
+	}
+
+	if modTime, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		obj.ModTime = modTime
+	}
+
+	return obj, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, name string) error {
+	res, err := s.do(ctx, http.MethodDelete, name, nil, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	return s3Error(res, http.StatusNoContent, http.StatusOK)
+}
+
+func s3Error(res *http.Response, expected ...int) error {
+	if slices.Contains(expected, res.StatusCode) {
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("s3 %s %s failed with %s: %s", res.Request.Method, res.Request.URL.Path, res.Status, msg)
+}
+
+// signV4 adds an AWS signature version 4 Authorization header to req, signing
+// every header already set on it.
+func signV4(req *http.Request, body []byte, region string, accessKey string, secretKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	canonicalHeaders := strings.Builder{}
+
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+secretKey), day)
+	key = hmacSha256(key, region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent encodes everything but the unreserved characters, as
+// signature version 4 requires. Slashes are kept in paths.
+func uriEncode(s string, encodeSlash bool) string {
+	out := strings.Builder{}
+
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			out.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			out.WriteByte(b)
+		default:
+			fmt.Fprintf(&out, "%%%02X", b)
+		}
+	}
+
+	return out.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}